@@ -0,0 +1,196 @@
+//! Animations for sliced spritesheets.
+//!
+//! The animations are written next to the image as `<name>.anim`.
+//! The file contains the number of animations and then for each of them:
+//! the name, the loop flag (u8), the number of frames,
+//! and for each frame the tile index (u16) and the duration in milliseconds (u16).
+
+use crate::binary::{write_len, write_str, Reader};
+use crate::config::{AnimationConfig, SliceConfig};
+use anyhow::{bail, Context, Result};
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+
+/// A named sequence of frames.
+#[derive(Serialize, Debug, PartialEq, Eq)]
+pub struct Animation {
+    pub name:      String,
+    pub frames:    Vec<u16>,
+    pub durations: Vec<u16>,
+    #[serde(rename = "loop")]
+    pub looped:    bool,
+}
+
+/// Validate the animations of the converted image and write them into the ROM.
+///
+/// The number of frames is taken from the already converted image
+/// so that it works the same when the image is restored from the cache.
+pub fn write_animations(
+    image_path: &Path,
+    slice: &SliceConfig,
+    animations: &BTreeMap<String, AnimationConfig>,
+) -> Result<()> {
+    let raw = fs::read(image_path).context("read converted image")?;
+    let Some(width) = raw.get(2..4) else {
+        bail!("the converted image is too short");
+    };
+    let width = u32::from(u16::from_le_bytes([width[0], width[1]]));
+    let frames = width / slice.tile_width.max(1);
+    let animations = collect_animations(animations, frames)?;
+    let raw = encode_animations(&animations)?;
+    let mut output_path = image_path.as_os_str().to_owned();
+    output_path.push(".anim");
+    fs::write(output_path, raw).context("write animations file")?;
+    Ok(())
+}
+
+/// Validate the animations and resolve the duration of each frame.
+fn collect_animations(
+    animations: &BTreeMap<String, AnimationConfig>,
+    frames: u32,
+) -> Result<Vec<Animation>> {
+    let mut result = Vec::new();
+    for (name, animation) in animations {
+        let animation = collect_animation(name, animation, frames)
+            .with_context(|| format!("invalid animation {name}"))?;
+        result.push(animation);
+    }
+    Ok(result)
+}
+
+fn collect_animation(name: &str, animation: &AnimationConfig, frames: u32) -> Result<Animation> {
+    if name.is_empty() {
+        bail!("name must not be empty");
+    }
+    if animation.frames.is_empty() {
+        bail!("there are no frames");
+    }
+    for (i, frame) in animation.frames.iter().enumerate() {
+        if u32::from(*frame) >= frames {
+            bail!("frame #{i} refers to the tile {frame} but the image has only {frames} tiles");
+        }
+    }
+    let durations = match (&animation.durations, animation.duration) {
+        (Some(durations), _) => {
+            if durations.len() != animation.frames.len() {
+                bail!(
+                    "there are {} durations for {} frames",
+                    durations.len(),
+                    animation.frames.len()
+                );
+            }
+            durations.clone()
+        }
+        (None, Some(duration)) => vec![duration; animation.frames.len()],
+        (None, None) => bail!("either duration or durations must be specified"),
+    };
+    if durations.contains(&0) {
+        bail!("durations must be positive");
+    }
+    Ok(Animation {
+        name: name.to_string(),
+        frames: animation.frames.clone(),
+        durations,
+        looped: animation.looped,
+    })
+}
+
+fn encode_animations(animations: &[Animation]) -> Result<Vec<u8>> {
+    let mut raw = Vec::new();
+    write_len(&mut raw, animations.len())?;
+    for animation in animations {
+        write_str(&mut raw, &animation.name)?;
+        raw.push(u8::from(animation.looped));
+        write_len(&mut raw, animation.frames.len())?;
+        for (frame, duration) in animation.frames.iter().zip(&animation.durations) {
+            raw.extend_from_slice(&frame.to_le_bytes());
+            raw.extend_from_slice(&duration.to_le_bytes());
+        }
+    }
+    Ok(raw)
+}
+
+pub fn decode_animations(raw: &[u8]) -> Result<Vec<Animation>> {
+    let mut reader = Reader::new(raw);
+    let count = reader.read_u16()?;
+    let mut animations = Vec::new();
+    for _ in 0..count {
+        let name = reader.read_str()?;
+        let looped = reader.read_u8()? != 0;
+        let len = reader.read_u16()?;
+        let mut frames = Vec::new();
+        let mut durations = Vec::new();
+        for _ in 0..len {
+            frames.push(reader.read_u16()?);
+            durations.push(reader.read_u16()?);
+        }
+        animations.push(Animation {
+            name,
+            frames,
+            durations,
+            looped,
+        });
+    }
+    Ok(animations)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn make_config(frames: Vec<u16>, duration: Option<u16>) -> AnimationConfig {
+        AnimationConfig {
+            frames,
+            duration,
+            durations: None,
+            looped: true,
+        }
+    }
+
+    #[test]
+    fn test_collect_animations() {
+        let mut animations = BTreeMap::new();
+        animations.insert("walk".to_string(), make_config(vec![0, 1, 2, 1], Some(100)));
+        let result = collect_animations(&animations, 3).unwrap();
+        assert_eq!(result[0].durations, vec![100; 4]);
+
+        let err = collect_animations(&animations, 2).unwrap_err();
+        assert_eq!(
+            format!("{err:#}"),
+            "invalid animation walk: frame #2 refers to the tile 2 but the image has only 2 tiles"
+        );
+
+        let mut animation = make_config(vec![0, 1], None);
+        assert!(collect_animation("idle", &animation, 3).is_err());
+        animation.durations = Some(vec![100]);
+        assert!(collect_animation("idle", &animation, 3).is_err());
+        animation.durations = Some(vec![100, 0]);
+        assert!(collect_animation("idle", &animation, 3).is_err());
+        animation.durations = Some(vec![100, 200]);
+        let result = collect_animation("idle", &animation, 3).unwrap();
+        assert_eq!(result.durations, vec![100, 200]);
+        assert!(collect_animation("idle", &make_config(vec![], Some(1)), 3).is_err());
+    }
+
+    #[test]
+    fn test_encode_decode() {
+        let animations = vec![
+            Animation {
+                name:      "walk".to_string(),
+                frames:    vec![0, 1, 2],
+                durations: vec![100, 100, 200],
+                looped:    true,
+            },
+            Animation {
+                name:      "die".to_string(),
+                frames:    vec![3],
+                durations: vec![500],
+                looped:    false,
+            },
+        ];
+        let raw = encode_animations(&animations).unwrap();
+        assert_eq!(decode_animations(&raw).unwrap(), animations);
+    }
+}
@@ -1,11 +1,20 @@
 #![allow(clippy::module_name_repetitions)]
 
+use crate::config::Lang;
 use clap::{Parser, Subcommand};
 use std::path::PathBuf;
 
 #[derive(Parser)]
 #[command(author, version, about, long_about = None)]
 pub struct Cli {
+    /// Output the result as JSON instead of human-readable text.
+    #[arg(long, global = true, default_value_t = false)]
+    pub json: bool,
+
+    /// Don't show the progress of long operations.
+    #[arg(short, long, global = true, default_value_t = false)]
+    pub quiet: bool,
+
     #[command(subcommand)]
     pub command: Commands,
 }
@@ -22,6 +31,39 @@ pub enum Commands {
     #[clap(alias("install"))]
     Import(ImportArgs),
 
+    /// Create a new project from a template.
+    #[clap(alias("create"))]
+    New(NewArgs),
+
+    /// Show all installed apps.
+    #[clap(alias("cat"), alias("ls"))]
+    List(ListArgs),
+
+    /// Show what changed between two ROMs.
+    Diff(DiffArgs),
+
+    /// Show how much disk space installed apps use.
+    #[clap(alias("usage"))]
+    Du,
+
+    /// Show metadata and files of an exported or installed app.
+    Inspect(InspectArgs),
+
+    /// Remove an installed app and all its data.
+    #[clap(alias("remove"))]
+    Uninstall(UninstallArgs),
+
+    /// Check that all tools needed to build the project are installed.
+    Doctor(DoctorArgs),
+
+    /// Check that installed apps are not corrupted.
+    ///
+    /// The ROM stores a single hash of all its files. So, if the hash doesn't match,
+    /// it's known that some files were changed, added, or removed, but not which ones.
+    /// Only missing required files are listed by name. To find the changed files,
+    /// compare the app with the original archive using `diff`.
+    Verify(VerifyArgs),
+
     /// Show the full path to the virtual filesystem.
     Vfs,
 
@@ -29,17 +71,54 @@ pub enum Commands {
     #[command(subcommand)]
     #[clap(alias("keys"))]
     Key(KeyCommands),
+
+    /// Commands to manage user-level settings, like the default author.
+    #[command(subcommand)]
+    #[clap(alias("settings"))]
+    Config(ConfigCommands),
+}
+
+#[derive(Subcommand, Debug)]
+pub enum ConfigCommands {
+    /// Change the setting value.
+    Set(ConfigSetArgs),
+
+    /// Show the setting value.
+    Get(ConfigGetArgs),
+
+    /// Show all settings.
+    #[clap(alias("ls"))]
+    List,
+}
+
+#[derive(Debug, Parser)]
+pub struct ConfigSetArgs {
+    /// The setting name (for example, `author`).
+    pub key: String,
+
+    /// The new value for the setting.
+    pub value: String,
+}
+
+#[derive(Debug, Parser)]
+pub struct ConfigGetArgs {
+    /// The setting name (for example, `author`).
+    pub key: String,
 }
 
 #[derive(Subcommand, Debug)]
 pub enum KeyCommands {
     /// Generate a new key pair.
     #[clap(alias("gen"), alias("generate"))]
-    New(KeyArgs),
+    New(KeyNewArgs),
 
     /// Add a new key from catalog, URL, or file.
     #[clap(alias("import"))]
-    Add(KeyArgs),
+    Add(KeyNewArgs),
+
+    /// Show all known keys and their fingerprints.
+    #[clap(alias("ls"))]
+    List,
 
     /// Export public key.
     #[clap(alias("export"), alias("public"))]
@@ -59,6 +138,15 @@ pub struct KeyArgs {
     pub author_id: String,
 }
 
+#[derive(Debug, Parser)]
+pub struct KeyNewArgs {
+    pub author_id: String,
+
+    /// Overwrite the existing key.
+    #[arg(short, long, default_value_t = false)]
+    pub force: bool,
+}
+
 #[derive(Debug, Parser)]
 pub struct KeyExportArgs {
     pub author_id: String,
@@ -69,6 +157,7 @@ pub struct KeyExportArgs {
 }
 
 #[derive(Debug, Parser)]
+#[allow(clippy::struct_excessive_bools)]
 pub struct BuildArgs {
     /// Path to the project root.
     #[arg(default_value = ".")]
@@ -82,6 +171,14 @@ pub struct BuildArgs {
     #[arg(short, long, default_value = None)]
     pub config: Option<PathBuf>,
 
+    /// The programming language of the app. Detected automatically if not specified.
+    #[arg(long, visible_alias = "target", value_enum, default_value = None)]
+    pub lang: Option<Lang>,
+
+    /// The author ID. Overrides `author_id` from firefly.toml and the default author.
+    #[arg(long, default_value = None)]
+    pub author: Option<String>,
+
     /// Don't optimize the binary.
     #[arg(long, default_value_t = false)]
     pub no_opt: bool,
@@ -90,9 +187,33 @@ pub struct BuildArgs {
     #[arg(long, default_value_t = false)]
     pub no_strip: bool,
 
+    /// Strip and optimize the binary, even if disabled in the config.
+    #[arg(long, default_value_t = false, conflicts_with_all = ["no_opt", "no_strip"])]
+    pub release: bool,
+
+    /// Don't use previously converted assets, convert all files from scratch.
+    #[arg(long, default_value_t = false)]
+    pub no_cache: bool,
+
     /// Don't show a random tip.
     #[arg(long, default_value_t = false)]
     pub no_tip: bool,
+
+    /// Fail if the ROM is bigger than that: bytes, KB, or MB (for example, `64KB`).
+    #[arg(long, default_value = None, value_parser = crate::budget::parse_size)]
+    pub max_size: Option<u64>,
+
+    /// Don't check that the binary imports only functions provided by the runtime.
+    #[arg(long, default_value_t = false)]
+    pub no_check_imports: bool,
+
+    /// Ignore unknown top-level keys in firefly.toml instead of failing.
+    #[arg(long, default_value_t = false)]
+    pub allow_unknown: bool,
+
+    /// Keep running and rebuild the project when any of its files change.
+    #[arg(short, long, default_value_t = false)]
+    pub watch: bool,
 }
 
 #[derive(Debug, Parser)]
@@ -109,9 +230,112 @@ pub struct ExportArgs {
     #[arg(long, default_value = None)]
     pub app: Option<String>,
 
-    /// Path to the archive.
+    /// Path to the archive or to the directory where to put it.
     #[arg(short, long, default_value = None)]
     pub output: Option<PathBuf>,
+
+    /// Overwrite the archive at the `--output` path if it already exists.
+    #[arg(short, long, default_value_t = false)]
+    pub force: bool,
+
+    /// Ignore unknown top-level keys in firefly.toml instead of failing.
+    #[arg(long, default_value_t = false)]
+    pub allow_unknown: bool,
+
+    /// Unix timestamp to use as the modification time of archived files.
+    ///
+    /// Defaults to `SOURCE_DATE_EPOCH` env var or, if not set, to 1980-01-01.
+    #[arg(long, default_value = None)]
+    pub source_date: Option<i64>,
+}
+
+#[derive(Debug, Parser)]
+pub struct NewArgs {
+    /// The app ID. Also used as the name of the project directory.
+    pub name: String,
+
+    /// The programming language of the app.
+    #[arg(long, value_enum, default_value = None)]
+    pub lang: Option<Lang>,
+
+    /// The project template to use.
+    #[arg(long, default_value = None)]
+    pub template: Option<String>,
+
+    /// The author ID.
+    #[arg(long, default_value = None)]
+    pub author: Option<String>,
+
+    /// Write into the directory even if it's not empty.
+    #[arg(short, long, default_value_t = false)]
+    pub force: bool,
+}
+
+#[derive(Debug, Parser)]
+pub struct ListArgs {
+    /// How to sort the list of apps.
+    #[arg(long, value_enum, default_value_t = SortBy::Name)]
+    pub sort: SortBy,
+}
+
+#[derive(Debug, Clone, Copy, clap::ValueEnum)]
+pub enum SortBy {
+    /// Sort by app ID, alphabetically.
+    Name,
+    /// Sort by size, the biggest first.
+    Size,
+}
+
+#[derive(Debug, Parser)]
+pub struct DiffArgs {
+    /// Path to the old archive or the full ID of the installed app.
+    pub old: String,
+
+    /// Path to the new archive or the full ID of the installed app.
+    pub new: String,
+}
+
+#[derive(Debug, Parser)]
+pub struct DoctorArgs {
+    /// Path to the project root.
+    #[arg(default_value = ".")]
+    pub root: PathBuf,
+
+    /// The programming language of the project. Detected automatically if not specified.
+    #[arg(long, value_enum, default_value = None)]
+    pub lang: Option<Lang>,
+}
+
+#[derive(Debug, Parser)]
+pub struct InspectArgs {
+    /// Path to the exported archive or the full ID of the installed app.
+    pub target: String,
+}
+
+#[derive(Debug, Parser)]
+pub struct UninstallArgs {
+    /// The full ID of the installed app (for example, `sys.launcher`).
+    pub id: String,
+
+    /// Show what would be removed without removing anything.
+    #[arg(long, default_value_t = false)]
+    pub dry_run: bool,
+}
+
+#[derive(Debug, Parser)]
+pub struct VerifyArgs {
+    /// The full ID of the installed app (for example, `sys.launcher`)
+    /// or path to the exported archive.
+    #[arg(required_unless_present = "all")]
+    pub id: Option<String>,
+
+    /// Check the signature using the given public key file or author ID.
+    #[arg(long, default_value = None)]
+    pub key: Option<String>,
+
+    /// Verify all installed apps.
+    #[arg(long, default_value_t = false, conflicts_with = "id")]
+    pub all: bool,
 }
 
 #[derive(Debug, Parser)]
@@ -127,6 +351,10 @@ pub struct ImportArgs {
     /// 3. App ID in the catalog (for example, `sys.launcher`).
     ///
     /// 4. The word "launcher" to install the latest version of the default launcher.
-    #[arg()]
-    pub path: String,
+    #[arg(required_unless_present = "from_url")]
+    pub path: Option<String>,
+
+    /// Download the ROM archive from the given URL.
+    #[arg(long, default_value = None, conflicts_with = "path")]
+    pub from_url: Option<String>,
 }
@@ -0,0 +1,524 @@
+//! Decoder for [Aseprite] files.
+//!
+//! Only the parts needed to get the final image are supported:
+//! layers (including groups and visibility), image cels, and palettes.
+//! All layers are blended using the "normal" blend mode.
+//!
+//! [Aseprite]: https://github.com/aseprite/aseprite/blob/main/docs/ase-file-specs.md
+use crate::binary::Reader;
+use crate::config::Frames;
+use anyhow::{bail, Context, Result};
+use flate2::read::ZlibDecoder;
+use image::{Rgba, RgbaImage};
+use std::io::Read;
+
+const FILE_MAGIC: u16 = 0xa5e0;
+const FRAME_MAGIC: u16 = 0xf1fa;
+
+const CHUNK_OLD_PALETTE: u16 = 0x0004;
+const CHUNK_LAYER: u16 = 0x2004;
+const CHUNK_CEL: u16 = 0x2005;
+const CHUNK_PALETTE: u16 = 0x2019;
+
+const CEL_RAW: u16 = 0;
+const CEL_LINKED: u16 = 1;
+const CEL_COMPRESSED: u16 = 2;
+
+const LAYER_VISIBLE: u16 = 1;
+const LAYER_BACKGROUND: u16 = 8;
+const LAYER_GROUP: u16 = 1;
+
+const TRANSPARENT: Rgba<u8> = Rgba([0, 0, 0, 0]);
+
+struct Header {
+    frames:            u16,
+    width:             u16,
+    height:            u16,
+    depth:             u16,
+    layer_opacity:     bool,
+    transparent_index: u8,
+}
+
+struct Layer {
+    visible:    bool,
+    background: bool,
+    group:      bool,
+    level:      u16,
+    opacity:    u8,
+}
+
+#[derive(Clone)]
+struct Cel {
+    layer:   usize,
+    x:       i32,
+    y:       i32,
+    opacity: u8,
+    width:   u32,
+    height:  u32,
+    /// Raw pixels in the color depth of the file.
+    data:    Vec<u8>,
+}
+
+/// Decode an Aseprite file into an image.
+///
+/// Layers of every frame are flattened into a single image. If frames are split,
+/// all frames are placed side by side, left to right, in a single spritesheet.
+/// Otherwise, only the first frame is used.
+pub fn decode_aseprite(raw: &[u8], frames: &Frames) -> Result<RgbaImage> {
+    let mut reader = Reader::new(raw);
+    let header = read_header(&mut reader).context("read header")?;
+    let mut layers = Vec::new();
+    let mut palette = Vec::new();
+    let mut old_palette = Vec::new();
+    let mut all_cels: Vec<Vec<Cel>> = Vec::new();
+    for frame in 0..header.frames {
+        let cels = read_frame(
+            &mut reader,
+            &header,
+            &mut layers,
+            &mut palette,
+            &mut old_palette,
+            &all_cels,
+        )
+        .with_context(|| format!("read frame {frame}"))?;
+        all_cels.push(cels);
+    }
+    // The old palette chunk must be ignored if there is a new one.
+    if palette.is_empty() {
+        palette = old_palette;
+    }
+
+    let decoder = Decoder {
+        header:  &header,
+        layers:  &layers,
+        palette: &palette,
+    };
+    let width = u32::from(header.width);
+    let height = u32::from(header.height);
+    match frames {
+        Frames::Flatten => {
+            let Some(cels) = all_cels.first() else {
+                bail!("the file has no frames");
+            };
+            Ok(decoder.render(cels))
+        }
+        Frames::Split => {
+            let count = u32::try_from(all_cels.len())?;
+            let mut sheet = RgbaImage::new(width * count, height);
+            for (cels, i) in all_cels.iter().zip(0u32..) {
+                let frame = decoder.render(cels);
+                image::imageops::replace(&mut sheet, &frame, i64::from(width * i), 0);
+            }
+            Ok(sheet)
+        }
+    }
+}
+
+/// Get the size of the image produced by [`decode_aseprite`] reading only the header.
+pub fn aseprite_size(raw: &[u8], frames: &Frames) -> Result<(u32, u32)> {
+    let mut reader = Reader::new(raw);
+    let header = read_header(&mut reader).context("read header")?;
+    let width = u32::from(header.width);
+    let height = u32::from(header.height);
+    match frames {
+        Frames::Flatten => Ok((width, height)),
+        Frames::Split => Ok((width * u32::from(header.frames), height)),
+    }
+}
+
+fn read_header(reader: &mut Reader) -> Result<Header> {
+    reader.read_u32()?; // file size
+    if reader.read_u16()? != FILE_MAGIC {
+        bail!("not an Aseprite file");
+    }
+    let frames = reader.read_u16()?;
+    let width = reader.read_u16()?;
+    let height = reader.read_u16()?;
+    let depth = reader.read_u16()?;
+    if depth != 32 && depth != 16 && depth != 8 {
+        bail!("unsupported color depth: {depth}");
+    }
+    let flags = reader.read_u32()?;
+    reader.skip(2 + 4 + 4)?; // speed and reserved
+    let transparent_index = reader.read_u8()?;
+    // Reserved, number of colors, pixel ratio, grid, and reserved.
+    reader.skip(3 + 2 + 2 + 8 + 84)?;
+    Ok(Header {
+        frames,
+        width,
+        height,
+        depth,
+        layer_opacity: flags & 1 != 0,
+        transparent_index,
+    })
+}
+
+fn read_frame(
+    reader: &mut Reader,
+    header: &Header,
+    layers: &mut Vec<Layer>,
+    palette: &mut Vec<Rgba<u8>>,
+    old_palette: &mut Vec<Rgba<u8>>,
+    prev_cels: &[Vec<Cel>],
+) -> Result<Vec<Cel>> {
+    let frame_start = reader.pos();
+    let frame_size = reader.read_u32()?;
+    if reader.read_u16()? != FRAME_MAGIC {
+        bail!("invalid frame header");
+    }
+    let old_chunks = reader.read_u16()?;
+    reader.skip(2 + 2)?; // duration and reserved
+    let new_chunks = reader.read_u32()?;
+    let chunks = if new_chunks == 0 {
+        u32::from(old_chunks)
+    } else {
+        new_chunks
+    };
+
+    let mut cels = Vec::new();
+    for _ in 0..chunks {
+        let chunk_size = reader.read_u32()? as usize;
+        let chunk_type = reader.read_u16()?;
+        let Some(body_size) = chunk_size.checked_sub(6) else {
+            bail!("invalid chunk size");
+        };
+        let mut body = Reader::new(reader.read_bytes(body_size)?);
+        match chunk_type {
+            CHUNK_LAYER => layers.push(read_layer(&mut body, header)?),
+            CHUNK_CEL => {
+                if let Some(cel) = read_cel(&mut body, header, prev_cels)? {
+                    cels.push(cel);
+                }
+            }
+            CHUNK_PALETTE => read_palette(&mut body, palette)?,
+            CHUNK_OLD_PALETTE => read_old_palette(&mut body, old_palette)?,
+            _ => {}
+        }
+    }
+    reader.seek(frame_start + frame_size as usize);
+    Ok(cels)
+}
+
+fn read_layer(reader: &mut Reader, header: &Header) -> Result<Layer> {
+    let flags = reader.read_u16()?;
+    let layer_type = reader.read_u16()?;
+    let level = reader.read_u16()?;
+    reader.skip(2 + 2 + 2)?; // default width, default height, blend mode
+    let opacity = reader.read_u8()?;
+    Ok(Layer {
+        visible: flags & LAYER_VISIBLE != 0,
+        background: flags & LAYER_BACKGROUND != 0,
+        group: layer_type == LAYER_GROUP,
+        level,
+        opacity: if header.layer_opacity { opacity } else { 255 },
+    })
+}
+
+/// Read a cel chunk. Returns None for cels that don't contain an image.
+fn read_cel(reader: &mut Reader, header: &Header, prev_cels: &[Vec<Cel>]) -> Result<Option<Cel>> {
+    let layer = reader.read_u16()? as usize;
+    let x = i32::from(reader.read_i16()?);
+    let y = i32::from(reader.read_i16()?);
+    let opacity = reader.read_u8()?;
+    let cel_type = reader.read_u16()?;
+    reader.skip(2 + 5)?; // z-index and reserved
+    let bpp = usize::from(header.depth / 8);
+    match cel_type {
+        CEL_RAW | CEL_COMPRESSED => {
+            let width = reader.read_u16()?;
+            let height = reader.read_u16()?;
+            let size = usize::from(width) * usize::from(height) * bpp;
+            let data = if cel_type == CEL_RAW {
+                reader.read_bytes(size)?.to_vec()
+            } else {
+                let mut data = Vec::with_capacity(size);
+                ZlibDecoder::new(reader.read_rest())
+                    .read_to_end(&mut data)
+                    .context("decompress cel")?;
+                data
+            };
+            if data.len() != size {
+                bail!(
+                    "cel has {} bytes of pixel data, expected {size}",
+                    data.len()
+                );
+            }
+            Ok(Some(Cel {
+                layer,
+                x,
+                y,
+                opacity,
+                width: u32::from(width),
+                height: u32::from(height),
+                data,
+            }))
+        }
+        CEL_LINKED => {
+            let frame = usize::from(reader.read_u16()?);
+            let Some(cels) = prev_cels.get(frame) else {
+                bail!("linked cel refers to unknown frame {frame}");
+            };
+            Ok(cels.iter().find(|cel| cel.layer == layer).cloned())
+        }
+        // Tilemaps and unknown future cel types.
+        _ => bail!("unsupported cel type: {cel_type}"),
+    }
+}
+
+fn read_palette(reader: &mut Reader, palette: &mut Vec<Rgba<u8>>) -> Result<()> {
+    let size = reader.read_u32()? as usize;
+    let first = reader.read_u32()? as usize;
+    let last = reader.read_u32()? as usize;
+    reader.skip(8)?; // reserved
+    if palette.len() < size {
+        palette.resize(size, TRANSPARENT);
+    }
+    for i in first..=last {
+        let flags = reader.read_u16()?;
+        let color = Rgba([
+            reader.read_u8()?,
+            reader.read_u8()?,
+            reader.read_u8()?,
+            reader.read_u8()?,
+        ]);
+        if flags & 1 != 0 {
+            let name_size = reader.read_u16()?; // color name
+            reader.skip(usize::from(name_size))?;
+        }
+        if i >= palette.len() {
+            palette.resize(i + 1, TRANSPARENT);
+        }
+        palette[i] = color;
+    }
+    Ok(())
+}
+
+fn read_old_palette(reader: &mut Reader, palette: &mut Vec<Rgba<u8>>) -> Result<()> {
+    let packets = reader.read_u16()?;
+    let mut index = 0;
+    for _ in 0..packets {
+        index += usize::from(reader.read_u8()?);
+        let count = match reader.read_u8()? {
+            0 => 256,
+            count => usize::from(count),
+        };
+        for _ in 0..count {
+            let color = Rgba([reader.read_u8()?, reader.read_u8()?, reader.read_u8()?, 255]);
+            if index >= palette.len() {
+                palette.resize(index + 1, TRANSPARENT);
+            }
+            palette[index] = color;
+            index += 1;
+        }
+    }
+    Ok(())
+}
+
+struct Decoder<'a> {
+    header:  &'a Header,
+    layers:  &'a [Layer],
+    palette: &'a [Rgba<u8>],
+}
+
+impl Decoder<'_> {
+    /// Blend together all visible cels of a frame.
+    fn render(&self, cels: &[Cel]) -> RgbaImage {
+        let visible = self.visible_layers();
+        let width = u32::from(self.header.width);
+        let height = u32::from(self.header.height);
+        let mut img = RgbaImage::from_pixel(width, height, TRANSPARENT);
+        let mut cels: Vec<&Cel> = cels.iter().collect();
+        cels.sort_by_key(|cel| cel.layer);
+        for cel in cels {
+            let Some(layer) = self.layers.get(cel.layer) else {
+                continue;
+            };
+            if layer.group || !visible[cel.layer] {
+                continue;
+            }
+            let opacity = mul(cel.opacity, layer.opacity);
+            self.draw_cel(&mut img, cel, layer, opacity);
+        }
+        img
+    }
+
+    fn draw_cel(&self, img: &mut RgbaImage, cel: &Cel, layer: &Layer, opacity: u8) {
+        let bpp = usize::from(self.header.depth / 8);
+        for cy in 0..cel.height {
+            for cx in 0..cel.width {
+                let Ok(x) = u32::try_from(cel.x + i32::try_from(cx).unwrap_or(i32::MAX)) else {
+                    continue;
+                };
+                let Ok(y) = u32::try_from(cel.y + i32::try_from(cy).unwrap_or(i32::MAX)) else {
+                    continue;
+                };
+                if x >= img.width() || y >= img.height() {
+                    continue;
+                }
+                let offset = (cy * cel.width + cx) as usize * bpp;
+                let pixel = &cel.data[offset..offset + bpp];
+                let mut src = self.decode_pixel(pixel, layer);
+                src.0[3] = mul(src.0[3], opacity);
+                let dst = img.get_pixel_mut(x, y);
+                *dst = blend(src, *dst);
+            }
+        }
+    }
+
+    fn decode_pixel(&self, pixel: &[u8], layer: &Layer) -> Rgba<u8> {
+        match pixel {
+            [r, g, b, a] => Rgba([*r, *g, *b, *a]),
+            [v, a] => Rgba([*v, *v, *v, *a]),
+            [i] => {
+                if *i == self.header.transparent_index && !layer.background {
+                    return TRANSPARENT;
+                }
+                self.palette
+                    .get(usize::from(*i))
+                    .copied()
+                    .unwrap_or(TRANSPARENT)
+            }
+            _ => TRANSPARENT,
+        }
+    }
+
+    /// Check for every layer if it is visible, taking into account visibility of groups.
+    fn visible_layers(&self) -> Vec<bool> {
+        let mut visible = Vec::with_capacity(self.layers.len());
+        // Visibility of the last seen layer (or group) on each nesting level.
+        let mut parents: Vec<bool> = Vec::new();
+        for layer in self.layers {
+            let level = usize::from(layer.level);
+            let parent_visible = match level.checked_sub(1) {
+                Some(parent) => parents.get(parent).copied().unwrap_or(true),
+                None => true,
+            };
+            let is_visible = layer.visible && parent_visible;
+            parents.truncate(level);
+            parents.push(is_visible);
+            visible.push(is_visible);
+        }
+        visible
+    }
+}
+
+/// Multiply two 0-255 values as if they were in 0.0-1.0 range.
+fn mul(a: u8, b: u8) -> u8 {
+    let res = u32::from(a) * u32::from(b) / 255;
+    u8::try_from(res).unwrap_or(u8::MAX)
+}
+
+/// Put the source color over the destination color ("normal" blend mode).
+fn blend(src: Rgba<u8>, dst: Rgba<u8>) -> Rgba<u8> {
+    let sa = u32::from(src.0[3]);
+    let da = u32::from(dst.0[3]);
+    let out_a = sa * 255 + da * (255 - sa);
+    if out_a == 0 {
+        return TRANSPARENT;
+    }
+    let mut out = [0u8; 4];
+    for ((out, sc), dc) in out.iter_mut().zip(src.0).zip(dst.0).take(3) {
+        let c = (u32::from(sc) * sa * 255 + u32::from(dc) * da * (255 - sa)) / out_a;
+        *out = u8::try_from(c).unwrap_or(u8::MAX);
+    }
+    out[3] = u8::try_from(out_a / 255).unwrap_or(u8::MAX);
+    Rgba(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const RED: [u8; 4] = [0xb1, 0x3e, 0x53, 0xff];
+    const BLUE: [u8; 4] = [0x3b, 0x5d, 0xc9, 0xff];
+
+    /// Build an RGBA Aseprite file with one layer and one raw 8x1 cel per frame.
+    fn make_file(frames: &[[u8; 4]]) -> Vec<u8> {
+        let mut out = Vec::new();
+        out.extend_from_slice(&0u32.to_le_bytes()); // file size
+        out.extend_from_slice(&FILE_MAGIC.to_le_bytes());
+        out.extend_from_slice(&u16::try_from(frames.len()).unwrap().to_le_bytes());
+        out.extend_from_slice(&8u16.to_le_bytes()); // width
+        out.extend_from_slice(&1u16.to_le_bytes()); // height
+        out.extend_from_slice(&32u16.to_le_bytes()); // depth
+        out.extend_from_slice(&1u32.to_le_bytes()); // flags
+        out.resize(128, 0);
+
+        for (i, color) in frames.iter().enumerate() {
+            let mut chunks = Vec::new();
+            if i == 0 {
+                let mut layer = Vec::new();
+                layer.extend_from_slice(&LAYER_VISIBLE.to_le_bytes());
+                layer.extend_from_slice(&[0; 10]); // type, level, size, blend mode
+                layer.push(255); // opacity
+                layer.extend_from_slice(&[0; 3]);
+                layer.extend_from_slice(&0u16.to_le_bytes()); // name
+                push_chunk(&mut chunks, CHUNK_LAYER, &layer);
+            }
+            let mut cel = Vec::new();
+            cel.extend_from_slice(&[0; 6]); // layer, x, y
+            cel.push(255); // opacity
+            cel.extend_from_slice(&CEL_RAW.to_le_bytes());
+            cel.extend_from_slice(&[0; 7]);
+            cel.extend_from_slice(&8u16.to_le_bytes());
+            cel.extend_from_slice(&1u16.to_le_bytes());
+            for _ in 0..8 {
+                cel.extend_from_slice(color);
+            }
+            push_chunk(&mut chunks, CHUNK_CEL, &cel);
+
+            let size = u32::try_from(16 + chunks.len()).unwrap();
+            out.extend_from_slice(&size.to_le_bytes());
+            out.extend_from_slice(&FRAME_MAGIC.to_le_bytes());
+            out.extend_from_slice(&[0; 6]);
+            let count = if i == 0 { 2u32 } else { 1u32 };
+            out.extend_from_slice(&count.to_le_bytes());
+            out.extend_from_slice(&chunks);
+        }
+        out
+    }
+
+    fn push_chunk(out: &mut Vec<u8>, chunk_type: u16, body: &[u8]) {
+        let size = u32::try_from(body.len() + 6).unwrap();
+        out.extend_from_slice(&size.to_le_bytes());
+        out.extend_from_slice(&chunk_type.to_le_bytes());
+        out.extend_from_slice(body);
+    }
+
+    #[test]
+    fn test_decode_aseprite_flatten() {
+        let raw = make_file(&[RED, BLUE]);
+        let img = decode_aseprite(&raw, &Frames::Flatten).unwrap();
+        assert_eq!(img.width(), 8);
+        assert_eq!(img.height(), 1);
+        assert_eq!(*img.get_pixel(3, 0), Rgba(RED));
+    }
+
+    #[test]
+    fn test_decode_aseprite_split() {
+        let raw = make_file(&[RED, BLUE]);
+        let img = decode_aseprite(&raw, &Frames::Split).unwrap();
+        assert_eq!(img.width(), 16);
+        assert_eq!(img.height(), 1);
+        assert_eq!(aseprite_size(&raw, &Frames::Split).unwrap(), (16, 1));
+        assert_eq!(aseprite_size(&raw, &Frames::Flatten).unwrap(), (8, 1));
+        assert_eq!(*img.get_pixel(7, 0), Rgba(RED));
+        assert_eq!(*img.get_pixel(8, 0), Rgba(BLUE));
+    }
+
+    #[test]
+    fn test_decode_aseprite_invalid() {
+        assert!(decode_aseprite(&[], &Frames::Flatten).is_err());
+        assert!(decode_aseprite(&[0; 128], &Frames::Flatten).is_err());
+    }
+
+    #[test]
+    fn test_blend() {
+        let red = Rgba(RED);
+        assert_eq!(blend(red, TRANSPARENT), red);
+        assert_eq!(blend(TRANSPARENT, red), red);
+        assert_eq!(blend(Rgba(BLUE), red), Rgba(BLUE));
+        let half = Rgba([0, 0, 0, 128]);
+        assert_eq!(blend(half, Rgba([255, 255, 255, 255])).0[0], 127);
+    }
+}
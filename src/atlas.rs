@@ -0,0 +1,176 @@
+use crate::binary::{write_len, write_str};
+use crate::config::{AtlasConfig, Config, FileConfig};
+use crate::images::{encode_image, load_image};
+use anyhow::{bail, Context, Result};
+use image::RgbaImage;
+use std::fs;
+
+/// The atlas width used if not specified in the config.
+const DEFAULT_WIDTH: u32 = 128;
+
+/// The position of an image inside of the atlas.
+#[derive(Debug, PartialEq, Eq)]
+struct Region {
+    name:   String,
+    x:      u32,
+    y:      u32,
+    width:  u32,
+    height: u32,
+}
+
+/// Pack all images of the atlas into a single image and write it into the ROM.
+///
+/// The image is written as `<name>` and the list of regions as `<name>.atlas`.
+pub fn build_atlas(config: &Config, name: &str, atlas_config: &AtlasConfig) -> Result<()> {
+    let mut images = Vec::new();
+    for path in &atlas_config.files {
+        let Some(stem) = path.file_stem().and_then(|s| s.to_str()) else {
+            bail!("cannot get file name for {}", path.display());
+        };
+        if images.iter().any(|(n, _)| n == stem) {
+            bail!("duplicate region name: {stem}");
+        }
+        let input_path = config.root_path.join(path);
+        let img = load_image(&input_path, &FileConfig::default())
+            .with_context(|| format!("load {}", path.display()))?;
+        images.push((stem.to_string(), img));
+    }
+    if images.is_empty() {
+        bail!("the atlas has no files");
+    }
+
+    let sizes: Vec<_> = images
+        .iter()
+        .map(|(name, img)| (name.as_str(), img.width(), img.height()))
+        .collect();
+    let width = atlas_config.width.unwrap_or(DEFAULT_WIDTH);
+    let (regions, width, height) = pack_shelves(&sizes, width);
+
+    let mut atlas = RgbaImage::new(width, height);
+    for region in &regions {
+        let Some((_, img)) = images.iter().find(|(n, _)| *n == region.name) else {
+            continue;
+        };
+        for (x, y, pixel) in img.enumerate_pixels() {
+            atlas.put_pixel(region.x + x, region.y + y, *pixel);
+        }
+    }
+    let output_path = config.rom_path.join(name);
+    let source = format!("atlas {name}");
+    let file_config = FileConfig {
+        remap: config.remap.clone().unwrap_or_default(),
+        ..FileConfig::default()
+    };
+    encode_image(atlas, &source, &output_path, &file_config)?;
+
+    let raw = encode_regions(&regions)?;
+    let output_path = config.rom_path.join(format!("{name}.atlas"));
+    fs::write(output_path, raw).context("write atlas regions")?;
+    Ok(())
+}
+
+/// Place the images using a simple shelf packer.
+///
+/// The images are sorted by height (and then by name, so that the layout is
+/// always the same for the same inputs) and placed left to right in rows.
+/// When the image doesn't fit into the current row, a new row is started.
+///
+/// Returns the regions and the size of the atlas. The atlas width is
+/// rounded up to a multiple of 8 as required by the image format.
+fn pack_shelves(sizes: &[(&str, u32, u32)], max_width: u32) -> (Vec<Region>, u32, u32) {
+    let mut sizes = sizes.to_vec();
+    sizes.sort_by(|a, b| b.2.cmp(&a.2).then(b.1.cmp(&a.1)).then(a.0.cmp(b.0)));
+    let widest = sizes.iter().map(|s| s.1).max().unwrap_or(0);
+    let max_width = max_width.max(widest);
+
+    let mut regions = Vec::new();
+    let (mut x, mut y, mut shelf_height) = (0, 0, 0);
+    let mut used_width = 0;
+    for (name, width, height) in sizes {
+        if x + width > max_width {
+            y += shelf_height;
+            x = 0;
+            shelf_height = 0;
+        }
+        regions.push(Region {
+            name: name.to_string(),
+            x,
+            y,
+            width,
+            height,
+        });
+        x += width;
+        used_width = used_width.max(x);
+        shelf_height = shelf_height.max(height);
+    }
+    let atlas_width = used_width.div_ceil(8) * 8;
+    (regions, atlas_width, y + shelf_height)
+}
+
+/// Serialize the regions.
+///
+/// The format is the number of regions followed by the regions.
+/// Each region is the name and then x, y, width, and height (each is u16).
+/// See `binary.rs` for how lengths and strings are encoded.
+fn encode_regions(regions: &[Region]) -> Result<Vec<u8>> {
+    let mut raw = Vec::new();
+    write_len(&mut raw, regions.len()).context("write regions count")?;
+    for region in regions {
+        write_str(&mut raw, &region.name).context("write region name")?;
+        for value in [region.x, region.y, region.width, region.height] {
+            let Ok(value) = u16::try_from(value) else {
+                bail!("the atlas is too big");
+            };
+            raw.extend_from_slice(&value.to_le_bytes());
+        }
+    }
+    Ok(raw)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pack_shelves() {
+        let sizes = [("a", 8, 8), ("b", 16, 16), ("c", 8, 16), ("d", 8, 8)];
+        let (regions, width, height) = pack_shelves(&sizes, 24);
+        assert_eq!(width, 24);
+        assert_eq!(height, 24);
+        let layout: Vec<_> = regions
+            .iter()
+            .map(|r| (r.name.as_str(), r.x, r.y))
+            .collect();
+        assert_eq!(
+            layout,
+            vec![("b", 0, 0), ("c", 16, 0), ("a", 0, 16), ("d", 8, 16)]
+        );
+
+        // The order of inputs doesn't affect the layout.
+        let sizes = [("d", 8, 8), ("c", 8, 16), ("b", 16, 16), ("a", 8, 8)];
+        let (regions2, _, _) = pack_shelves(&sizes, 24);
+        assert_eq!(regions, regions2);
+    }
+
+    #[test]
+    fn test_pack_shelves_wide_image() {
+        let sizes = [("a", 20, 4), ("b", 4, 4)];
+        let (regions, width, height) = pack_shelves(&sizes, 8);
+        assert_eq!(width, 24);
+        assert_eq!(height, 8);
+        assert_eq!(regions[1].y, 4);
+    }
+
+    #[test]
+    fn test_encode_regions() {
+        let regions = vec![Region {
+            name:   "hi".to_string(),
+            x:      1,
+            y:      2,
+            width:  3,
+            height: 258,
+        }];
+        let raw = encode_regions(&regions).unwrap();
+        assert_eq!(raw, vec![1, 0, 2, 0, b'h', b'i', 1, 0, 2, 0, 3, 0, 2, 1]);
+    }
+}
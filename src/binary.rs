@@ -0,0 +1,116 @@
+//! Helpers for the simple binary formats of ROM files produced by the CLI.
+//!
+//! All numbers are little-endian and all strings are prefixed by their length as u16.
+
+use anyhow::{bail, Context, Result};
+
+/// Write the length of a list or a string.
+pub fn write_len(raw: &mut Vec<u8>, len: usize) -> Result<()> {
+    let Ok(len) = u16::try_from(len) else {
+        bail!("too many items");
+    };
+    raw.extend_from_slice(&len.to_le_bytes());
+    Ok(())
+}
+
+pub fn write_str(raw: &mut Vec<u8>, s: &str) -> Result<()> {
+    write_len(raw, s.len())?;
+    raw.extend_from_slice(s.as_bytes());
+    Ok(())
+}
+
+/// Read little-endian numbers and other values from a byte slice.
+pub struct Reader<'a> {
+    raw: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> Reader<'a> {
+    pub const fn new(raw: &'a [u8]) -> Self {
+        Self { raw, pos: 0 }
+    }
+
+    /// The current offset from the start of the slice.
+    pub const fn pos(&self) -> usize {
+        self.pos
+    }
+
+    /// Move to the given offset from the start of the slice.
+    pub const fn seek(&mut self, pos: usize) {
+        self.pos = pos;
+    }
+
+    pub fn read_bytes(&mut self, n: usize) -> Result<&'a [u8]> {
+        let Some(bytes) = self.raw.get(self.pos..self.pos + n) else {
+            bail!("unexpected end of file");
+        };
+        self.pos += n;
+        Ok(bytes)
+    }
+
+    /// Read everything that is left.
+    pub fn read_rest(&mut self) -> &'a [u8] {
+        let rest = self.raw.get(self.pos..).unwrap_or_default();
+        self.pos = self.raw.len();
+        rest
+    }
+
+    pub fn skip(&mut self, n: usize) -> Result<()> {
+        self.read_bytes(n)?;
+        Ok(())
+    }
+
+    pub fn read_u8(&mut self) -> Result<u8> {
+        Ok(self.read_bytes(1)?[0])
+    }
+
+    pub fn read_u16(&mut self) -> Result<u16> {
+        let bytes = self.read_bytes(2)?;
+        Ok(u16::from_le_bytes([bytes[0], bytes[1]]))
+    }
+
+    pub fn read_i16(&mut self) -> Result<i16> {
+        let bytes = self.read_bytes(2)?;
+        Ok(i16::from_le_bytes([bytes[0], bytes[1]]))
+    }
+
+    pub fn read_u32(&mut self) -> Result<u32> {
+        let bytes = self.read_bytes(4)?;
+        Ok(u32::from_le_bytes([bytes[0], bytes[1], bytes[2], bytes[3]]))
+    }
+
+    pub fn read_str(&mut self) -> Result<String> {
+        let len = self.read_u16()?;
+        let bytes = self.read_bytes(usize::from(len))?;
+        let s = std::str::from_utf8(bytes).context("invalid UTF-8")?;
+        Ok(s.to_string())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_write_read() {
+        let mut raw = Vec::new();
+        write_str(&mut raw, "hello").unwrap();
+        write_len(&mut raw, 300).unwrap();
+        raw.push(7);
+        let mut reader = Reader::new(&raw);
+        assert_eq!(reader.read_str().unwrap(), "hello");
+        assert_eq!(reader.read_u16().unwrap(), 300);
+        assert_eq!(reader.read_u8().unwrap(), 7);
+        assert!(reader.read_u8().is_err());
+
+        let raw = [1, 2, 0xfe, 0xff, 1, 0, 0, 0, 9, 9];
+        let mut reader = Reader::new(&raw);
+        reader.skip(2).unwrap();
+        assert_eq!(reader.read_i16().unwrap(), -2);
+        assert_eq!(reader.read_u32().unwrap(), 1);
+        assert_eq!(reader.pos(), 8);
+        assert_eq!(reader.read_rest(), &[9, 9]);
+        reader.seek(0);
+        assert_eq!(reader.read_bytes(2).unwrap(), &[1, 2]);
+    }
+}
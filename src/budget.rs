@@ -0,0 +1,161 @@
+use crate::config::SizeConfig;
+use crate::file_names::BIN;
+use anyhow::{bail, Result};
+use std::collections::HashMap;
+use std::ffi::OsString;
+
+const KB: u64 = 1024;
+const MB: u64 = 1024 * KB;
+
+/// The ROM size, split into the code and everything else.
+#[derive(Debug, PartialEq, Eq)]
+pub struct RomSize {
+    pub code:   u64,
+    pub assets: u64,
+}
+
+impl RomSize {
+    pub fn new(sizes: &HashMap<OsString, u64>) -> Self {
+        let total: u64 = sizes.values().sum();
+        let code = sizes.get(&OsString::from(BIN)).copied().unwrap_or(0);
+        Self {
+            code,
+            assets: total - code,
+        }
+    }
+
+    pub const fn total(&self) -> u64 {
+        self.code + self.assets
+    }
+}
+
+/// Make sure the ROM fits into the size budget and return how much space is left.
+pub fn check_budget(size: &RomSize, max_size: u64) -> Result<u64> {
+    let total = size.total();
+    if total > max_size {
+        bail!(
+            "the ROM is {total} bytes, which is {} bytes over the budget of {max_size} bytes \
+            (code: {} bytes, assets: {} bytes)",
+            total - max_size,
+            size.code,
+            size.assets,
+        );
+    }
+    Ok(max_size - total)
+}
+
+/// Resolve the size budget from firefly.toml.
+pub fn resolve_size(size: &SizeConfig) -> Result<u64> {
+    match size {
+        SizeConfig::Bytes(size) => Ok(*size),
+        SizeConfig::Text(raw) => match parse_size(raw) {
+            Ok(size) => Ok(size),
+            Err(err) => bail!("invalid max_size: {err}"),
+        },
+    }
+}
+
+/// Pick the unit to show the size in: bytes (no unit), Kb, or Mb.
+///
+/// Returns how many bytes are in the unit and the unit name.
+pub const fn size_unit(size: u64) -> (u64, &'static str) {
+    if size > MB {
+        (MB, "Mb")
+    } else if size > KB {
+        (KB, "Kb")
+    } else {
+        (1, "")
+    }
+}
+
+/// Convert big file size into Kb or Mb.
+pub fn format_size(size: u64) -> String {
+    match size_unit(size) {
+        (_, "") => format!("{size}"),
+        (unit_size, unit) => format!("{} {unit}", size / unit_size),
+    }
+}
+
+/// Parse size in bytes with an optional suffix: "4096", "64KB", "1MB".
+pub fn parse_size(raw: &str) -> Result<u64, String> {
+    let upper = raw.trim().to_ascii_uppercase();
+    let (number, unit) = if let Some(number) = upper.strip_suffix("MB") {
+        (number, MB)
+    } else if let Some(number) = upper.strip_suffix("KB") {
+        (number, KB)
+    } else if let Some(number) = upper.strip_suffix('M') {
+        (number, MB)
+    } else if let Some(number) = upper.strip_suffix('K') {
+        (number, KB)
+    } else {
+        (upper.strip_suffix('B').unwrap_or(&upper), 1)
+    };
+    let Ok(number) = number.trim().parse::<u64>() else {
+        return Err(format!("must be a number of bytes, KB, or MB, got {raw:?}"));
+    };
+    match number.checked_mul(unit) {
+        Some(size) if size > 0 => Ok(size),
+        Some(_) => Err("must be positive".to_string()),
+        None => Err(format!("{raw:?} is too big")),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_size() {
+        assert_eq!(format_size(100), "100");
+        assert_eq!(format_size(1024), "1024");
+        assert_eq!(format_size(2048), "2 Kb");
+        assert_eq!(format_size(3 * 1024 * 1024 + 1), "3 Mb");
+    }
+
+    #[test]
+    fn test_parse_size() {
+        assert_eq!(parse_size("4096"), Ok(4096));
+        assert_eq!(parse_size("100B"), Ok(100));
+        assert_eq!(parse_size("64KB"), Ok(64 * 1024));
+        assert_eq!(parse_size("64k"), Ok(64 * 1024));
+        assert_eq!(parse_size("2 MB"), Ok(2 * 1024 * 1024));
+        assert!(parse_size("0").is_err());
+        assert!(parse_size("1.5MB").is_err());
+        assert!(parse_size("big").is_err());
+        assert!(parse_size("").is_err());
+    }
+
+    #[test]
+    fn test_resolve_size() {
+        use crate::config::BuildConfig;
+        let config: BuildConfig = toml::from_str("max_size = 4096").unwrap();
+        assert_eq!(resolve_size(&config.max_size.unwrap()).unwrap(), 4096);
+        let config: BuildConfig = toml::from_str("max_size = \"4KB\"").unwrap();
+        assert_eq!(resolve_size(&config.max_size.unwrap()).unwrap(), 4096);
+        let config: BuildConfig = toml::from_str("max_size = \"lots\"").unwrap();
+        assert!(resolve_size(&config.max_size.unwrap()).is_err());
+    }
+
+    #[test]
+    fn test_check_budget() {
+        let mut sizes = HashMap::new();
+        sizes.insert(OsString::from(BIN), 600);
+        sizes.insert(OsString::from("font"), 300);
+        let size = RomSize::new(&sizes);
+        assert_eq!(
+            size,
+            RomSize {
+                code:   600,
+                assets: 300,
+            }
+        );
+        assert_eq!(check_budget(&size, 1000).unwrap(), 100);
+        assert_eq!(check_budget(&size, 900).unwrap(), 0);
+        let err = check_budget(&size, 800).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "the ROM is 900 bytes, which is 100 bytes over the budget of 800 bytes \
+            (code: 600 bytes, assets: 300 bytes)"
+        );
+    }
+}
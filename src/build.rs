@@ -1,10 +1,22 @@
+use crate::anim::write_animations;
 use crate::args::BuildArgs;
-use crate::config::{Config, FileConfig};
+use crate::atlas::build_atlas;
+use crate::budget::{check_budget, resolve_size, size_unit, RomSize};
+use crate::cache::{make_atlas_key, make_key, Cache};
+use crate::codegen::write_codegen;
+use crate::config::{AtlasConfig, Config, FileConfig};
 use crate::crypto::hash_dir;
-use crate::file_names::{HASH, KEY, META, SIG};
-use crate::images::convert_image;
+use crate::file_names::{BADGES, BOARDS, HASH, KEY, LOCALES, META, SIG};
+use crate::images::{check_image_size, convert_image};
 use crate::langs::build_bin;
+use crate::locales::write_locales;
+use crate::output::{is_json, print_json};
+use crate::progress::{Progress, Unit};
+use crate::settings::Settings;
+use crate::stats::{write_badges, write_boards};
+use crate::tilemap::convert_tilemap;
 use crate::vfs::init_vfs;
+use crate::watch::watch_build;
 use anyhow::{bail, Context};
 use colored::Colorize;
 use data_encoding::HEXLOWER;
@@ -14,12 +26,17 @@ use rsa::pkcs1v15::SigningKey;
 use rsa::signature::hazmat::PrehashSigner;
 use rsa::signature::SignatureEncoding;
 use rsa::RsaPrivateKey;
+use serde::Serialize;
 use sha2::{Digest, Sha256};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use std::ffi::OsString;
+use std::fmt::Write as _;
 use std::fs;
 use std::io::Write;
+use std::num::NonZeroUsize;
 use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Mutex;
 
 static TIPS: &[&str] = &[
     "use `firefly_cli export` to share the app with your friends",
@@ -49,23 +66,79 @@ static TIPS: &[&str] = &[
     "https://youtu.be/dQw4w9WgXcQ",
 ];
 
+/// The result of the build, for the JSON output.
+#[derive(Serialize)]
+struct BuildInfo {
+    id:         String,
+    files:      BTreeMap<String, u64>,
+    total_size: u64,
+    max_size:   Option<u64>,
+}
+
 pub fn cmd_build(vfs: PathBuf, args: &BuildArgs) -> anyhow::Result<()> {
+    if !args.no_tip && !is_json() {
+        show_tip();
+    }
+    if args.watch {
+        return watch_build(&vfs, args);
+    }
+    build(vfs, args)
+}
+
+/// Build the project once and install it into VFS.
+pub fn build(vfs: PathBuf, args: &BuildArgs) -> anyhow::Result<()> {
     init_vfs(&vfs).context("init vfs")?;
-    let config = Config::load(vfs, &args.root).context("load project config")?;
-    if config.author_id == "joearms" {
-        println!("⚠️  author_id in firefly.tom has the default value.");
-        println!("  Please, change it before sharing the app with the world.");
+    let mut config = Config::load_with(
+        vfs,
+        &args.root,
+        args.allow_unknown,
+        args.author.as_deref(),
+        Settings::load,
+    )
+    .context("load project config")?;
+    for key in &config.unknown_keys {
+        eprintln!("⚠️  unknown key in firefly.toml is ignored: {key}");
     }
-    if !args.no_tip {
-        show_tip();
+    if let Some(lang) = &args.lang {
+        config.lang = Some(lang.clone());
+    }
+    let max_size = match (args.max_size, &config.build.max_size) {
+        (Some(max_size), _) => Some(max_size),
+        (None, Some(max_size)) => Some(resolve_size(max_size)?),
+        (None, None) => None,
+    };
+    if config.author_id == "joearms" {
+        eprintln!("⚠️  author_id in firefly.tom has the default value.");
+        eprintln!("  Please, change it before sharing the app with the world.");
     }
     let old_sizes = collect_sizes(&config.rom_path);
     _ = fs::remove_dir_all(&config.rom_path);
     write_meta(&config).context("write metadata file")?;
+    // Must go before the binary is built because the app code may use the generated file.
+    if let Some(codegen) = &config.codegen {
+        write_codegen(&config, codegen).context("generate code")?;
+    }
     build_bin(&config, args).context("build binary")?;
+    let cache = Cache::open(&config.vfs_path, !args.no_cache).context("open cache")?;
     if let Some(files) = &config.files {
-        for (name, file_config) in files {
-            convert_file(name, &config, file_config).context("convert file")?;
+        convert_files(&config, files, &cache)?;
+    }
+    if config.description.is_some() || config.locales.is_some() {
+        write_locales(&config).context("write locales")?;
+    }
+    if let Some(badges) = &config.badges {
+        write_badges(&config, badges).context("write badges")?;
+    }
+    if let Some(boards) = &config.boards {
+        write_boards(&config, boards).context("write boards")?;
+    }
+    if let Some(atlases) = &config.atlases {
+        let mut atlases: Vec<_> = atlases.iter().collect();
+        atlases.sort_by(|a, b| a.0.cmp(b.0));
+        for (name, atlas_config) in atlases {
+            check_atlas_name(&config, name)?;
+            convert_atlas(&config, name, atlas_config, &cache)
+                .with_context(|| format!("build atlas {name}"))?;
         }
     }
     write_installed(&config).context("write app-name")?;
@@ -74,8 +147,31 @@ pub fn cmd_build(vfs: PathBuf, args: &BuildArgs) -> anyhow::Result<()> {
     write_sig(&config).context("sign ROM")?;
     let new_sizes = collect_sizes(&config.rom_path);
     check_sizes(&new_sizes)?;
+    let rom_size = RomSize::new(&new_sizes);
+    let headroom = match max_size {
+        Some(max_size) => Some(check_budget(&rom_size, max_size)?),
+        None => None,
+    };
+    let id = format!("{}.{}", config.author_id, config.app_id);
+    if is_json() {
+        let files: BTreeMap<String, u64> = new_sizes
+            .iter()
+            .filter_map(|(name, size)| Some((name.to_str()?.to_string(), *size)))
+            .collect();
+        let total_size = files.values().sum();
+        return print_json(&BuildInfo {
+            id,
+            files,
+            total_size,
+            max_size,
+        });
+    }
     print_sizes(&old_sizes, &new_sizes);
-    println!("\n✅ installed: {}.{}", config.author_id, config.app_id);
+    if let (Some(max_size), Some(headroom)) = (max_size, headroom) {
+        let total = rom_size.total();
+        println!("📦 ROM size: {total} of {max_size} bytes, {headroom} bytes left");
+    }
+    println!("\n✅ installed: {id}");
     Ok(())
 }
 
@@ -128,9 +224,115 @@ fn write_installed(config: &Config) -> anyhow::Result<()> {
     Ok(())
 }
 
+/// Convert all files from the config in parallel, one worker per CPU core.
+///
+/// A failure doesn't stop other files from being converted,
+/// and all failures are reported together at the end.
+fn convert_files(
+    config: &Config,
+    files: &HashMap<String, FileConfig>,
+    cache: &Cache,
+) -> anyhow::Result<()> {
+    let mut files: Vec<_> = files.iter().collect();
+    files.sort_by(|a, b| a.0.cmp(b.0));
+    let workers = std::thread::available_parallelism().map_or(1, NonZeroUsize::get);
+    let workers = workers.min(files.len());
+    let next = AtomicUsize::new(0);
+    let errors = Mutex::new(Vec::new());
+    let progress = Progress::new("converting files", Unit::Items, Some(files.len() as u64));
+    std::thread::scope(|s| {
+        for _ in 0..workers {
+            s.spawn(|| loop {
+                let i = next.fetch_add(1, Ordering::Relaxed);
+                let Some((name, file_config)) = files.get(i) else {
+                    break;
+                };
+                if let Err(err) = convert_file(name, config, file_config, cache) {
+                    errors.lock().unwrap().push((i, err));
+                }
+                progress.inc(1);
+            });
+        }
+    });
+    progress.finish();
+
+    let mut errors = errors.into_inner().unwrap();
+    if errors.is_empty() {
+        return Ok(());
+    }
+    // Workers finish in random order but the report should be stable.
+    errors.sort_by_key(|(i, _)| *i);
+    let mut msg = format!("cannot convert {} file(s):", errors.len());
+    for (i, err) in errors {
+        let name = files[i].0;
+        _ = write!(msg, "\n  {name}: {err:#}");
+    }
+    bail!(msg)
+}
+
+/// Make sure the atlas files don't overwrite any other ROM files.
+fn check_atlas_name(config: &Config, name: &str) -> anyhow::Result<()> {
+    if name.starts_with('_') {
+        bail!("ROM file name \"{name}\" is reserved");
+    }
+    if let Some(files) = &config.files {
+        let regions_name = format!("{name}.atlas");
+        if files.contains_key(name) || files.contains_key(&regions_name) {
+            bail!("atlas \"{name}\" conflicts with a file of the same name");
+        }
+    }
+    Ok(())
+}
+
+/// Build the atlas or restore it from the cache if none of the images changed.
+fn convert_atlas(
+    config: &Config,
+    name: &str,
+    atlas_config: &AtlasConfig,
+    cache: &Cache,
+) -> anyhow::Result<()> {
+    let image_key = make_atlas_key(config, atlas_config).context("make cache key")?;
+    let regions_key = format!("{image_key}.atlas");
+    let image_path = config.rom_path.join(name);
+    let regions_path = config.rom_path.join(format!("{name}.atlas"));
+    if cache.restore(&image_key, &image_path)? && cache.restore(&regions_key, &regions_path)? {
+        return Ok(());
+    }
+    build_atlas(config, name, atlas_config)?;
+    cache
+        .save(&image_key, &image_path)
+        .context("save into cache")?;
+    cache
+        .save(&regions_key, &regions_path)
+        .context("save into cache")?;
+    Ok(())
+}
+
+/// Make sure the animations file doesn't overwrite any other ROM file.
+fn check_anim_name(config: &Config, name: &str) -> anyhow::Result<()> {
+    let anim_name = format!("{name}.anim");
+    let in_files = config
+        .files
+        .as_ref()
+        .is_some_and(|files| files.contains_key(&anim_name));
+    let in_atlases = config
+        .atlases
+        .as_ref()
+        .is_some_and(|atlases| atlases.contains_key(&anim_name));
+    if in_files || in_atlases {
+        bail!("animations of \"{name}\" conflict with a file of the same name");
+    }
+    Ok(())
+}
+
 /// Get a file from config, convert it if needed, and write into the ROM.
-fn convert_file(name: &str, config: &Config, file_config: &FileConfig) -> anyhow::Result<()> {
-    if name == SIG || name == META || name == HASH || name == KEY {
+fn convert_file(
+    name: &str,
+    config: &Config,
+    file_config: &FileConfig,
+    cache: &Cache,
+) -> anyhow::Result<()> {
+    if [SIG, META, HASH, KEY, BADGES, BOARDS, LOCALES].contains(&name) {
         bail!("ROM file name \"{name}\" is reserved");
     }
     let output_path = config.rom_path.join(name);
@@ -150,8 +352,24 @@ fn convert_file(name: &str, config: &Config, file_config: &FileConfig) -> anyhow
         bail!("cannot convert file extension to string");
     };
     match extension {
-        "png" => {
-            convert_image(input_path, &output_path)?;
+        "png" | "ase" | "aseprite" => {
+            check_image_size(input_path, file_config)?;
+            let key = make_key(input_path, file_config).context("make cache key")?;
+            if !cache.restore(&key, &output_path)? {
+                convert_image(input_path, &output_path, file_config)?;
+                cache.save(&key, &output_path).context("save into cache")?;
+            }
+            if !file_config.animations.is_empty() {
+                check_anim_name(config, name)?;
+                let Some(slice) = &file_config.slice else {
+                    bail!("animations can be used only for sliced images");
+                };
+                write_animations(&output_path, slice, &file_config.animations)
+                    .context("write animations")?;
+            }
+        }
+        "tmx" | "csv" => {
+            convert_tilemap(input_path, &output_path, file_config)?;
         }
         // firefly formats for fonts and images
         "fff" | "ffi" | "ffz" => {
@@ -216,12 +434,12 @@ fn write_sig(config: &Config) -> anyhow::Result<()> {
     let author_id = &config.author_id;
     let pub_path = sys_path.join("pub").join(author_id);
     if !pub_path.exists() {
-        println!("⚠️  no key found for {author_id}, cannot sign ROM");
+        eprintln!("⚠️  no key found for {author_id}, cannot sign ROM");
         return Ok(());
     }
     let priv_path = sys_path.join("priv").join(author_id);
     if !priv_path.exists() {
-        println!("⚠️  there is only public key for {author_id}, cannot sign ROM");
+        eprintln!("⚠️  there is only public key for {author_id}, cannot sign ROM");
         return Ok(());
     }
 
@@ -299,14 +517,16 @@ fn print_sizes(old_sizes: &HashMap<OsString, u64>, new_sizes: &HashMap<OsString,
         };
 
         // convert big file size into Kb or Mb.
-        let new_size = if *new_size > 1024 * 1024 {
-            let new_size = new_size / 1024 / 1024;
-            format!("{new_size:>7} {}", "Mb".purple())
-        } else if *new_size > 1024 {
-            let new_size = new_size / 1024;
-            format!("{new_size:>7} {}", "Kb".blue())
-        } else {
-            format!("{new_size:>10}")
+        let new_size = match size_unit(*new_size) {
+            (_, "") => format!("{new_size:>10}"),
+            (unit_size, unit) => {
+                let unit = if unit == "Mb" {
+                    unit.purple()
+                } else {
+                    unit.blue()
+                };
+                format!("{:>7} {unit}", new_size / unit_size)
+            }
         };
 
         println!("{name:16} {new_size}{suffix}");
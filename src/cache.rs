@@ -0,0 +1,204 @@
+use crate::config::{AtlasConfig, Config, FileConfig};
+use anyhow::Context;
+use data_encoding::HEXLOWER;
+use sha2::{Digest, Sha256};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// The file inside of the cache dir containing the CLI version that created the cache.
+const VERSION_FILE: &str = "version";
+
+/// The CLI version. Different versions may produce different binary formats.
+const VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Counter making temporary file names unique across parallel conversions.
+static TMP_COUNTER: AtomicUsize = AtomicUsize::new(0);
+
+/// Storage for converted assets that lets the build skip unchanged files.
+///
+/// Every entry is a file named after the hash of the source file content
+/// and the conversion parameters.
+pub struct Cache {
+    root: PathBuf,
+
+    /// If false, cached entries are never used but new results are still saved.
+    read: bool,
+}
+
+impl Cache {
+    /// Open the cache in the given VFS, dropping it if it was created by another CLI version.
+    pub fn open(vfs: &Path, read: bool) -> anyhow::Result<Self> {
+        let root = vfs.join("cache");
+        let version_path = root.join(VERSION_FILE);
+        let version = fs::read_to_string(&version_path).unwrap_or_default();
+        if version != VERSION {
+            _ = fs::remove_dir_all(&root);
+            fs::create_dir_all(&root).context("create cache dir")?;
+            fs::write(&version_path, VERSION).context("write cache version")?;
+        }
+        Ok(Self { root, read })
+    }
+
+    /// Copy the cached entry into the output path. Returns false if there is no entry.
+    pub fn restore(&self, key: &str, output_path: &Path) -> anyhow::Result<bool> {
+        if !self.read {
+            return Ok(false);
+        }
+        let cache_path = self.root.join(key);
+        if !cache_path.is_file() {
+            return Ok(false);
+        }
+        copy_atomic(&cache_path, output_path).context("copy file from cache")?;
+        Ok(true)
+    }
+
+    /// Save the converted file into the cache.
+    pub fn save(&self, key: &str, output_path: &Path) -> anyhow::Result<()> {
+        let cache_path = self.root.join(key);
+        copy_atomic(output_path, &cache_path).context("copy file into cache")?;
+        Ok(())
+    }
+}
+
+/// Copy the file through a temporary file in the target dir.
+///
+/// The target is replaced by a rename, so it is never seen half-written,
+/// neither after an interrupted copy nor by another conversion running in parallel.
+fn copy_atomic(from: &Path, to: &Path) -> anyhow::Result<()> {
+    let n = TMP_COUNTER.fetch_add(1, Ordering::Relaxed);
+    let Some(file_name) = to.file_name() else {
+        anyhow::bail!("the path has no file name");
+    };
+    let file_name = file_name.to_string_lossy();
+    let tmp_path = to.with_file_name(format!(".{file_name}.{}.{n}.tmp", std::process::id()));
+    let result = fs::copy(from, &tmp_path).and_then(|_| fs::rename(&tmp_path, to));
+    if result.is_err() {
+        _ = fs::remove_file(&tmp_path);
+    }
+    result?;
+    Ok(())
+}
+
+/// Generate the cache key for converting the given file with the given config.
+///
+/// The key changes if either the file content or any of the conversion parameters change.
+pub fn make_key(input_path: &Path, file_config: &FileConfig) -> anyhow::Result<String> {
+    let mut hasher = Sha256::new();
+    hasher.update(VERSION);
+    hasher.update("\x00");
+    hasher.update(format!("{file_config:?}"));
+    hasher.update("\x00");
+    let mut file = fs::File::open(input_path).context("open file")?;
+    std::io::copy(&mut file, &mut hasher).context("read file")?;
+    Ok(HEXLOWER.encode(&hasher.finalize()))
+}
+
+/// Generate the cache key for building the atlas with the given config.
+///
+/// The key changes if any of the images or the atlas parameters change.
+pub fn make_atlas_key(config: &Config, atlas_config: &AtlasConfig) -> anyhow::Result<String> {
+    let mut hasher = Sha256::new();
+    hasher.update(VERSION);
+    hasher.update("\x00");
+    hasher.update(format!("{atlas_config:?}"));
+    hasher.update("\x00");
+    // The global palette remap is applied to the atlas image.
+    hasher.update(format!("{:?}", config.remap));
+    for path in &atlas_config.files {
+        hasher.update("\x00");
+        let mut file = fs::File::open(config.root_path.join(path)).context("open file")?;
+        std::io::copy(&mut file, &mut hasher).context("read file")?;
+    }
+    Ok(HEXLOWER.encode(&hasher.finalize()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_cache_save_restore() {
+        let vfs = make_tmp_vfs();
+        let cache = Cache::open(&vfs, true).unwrap();
+        let in_path = vfs.join("in");
+        let out_path = vfs.join("out");
+        assert!(!cache.restore("somekey", &out_path).unwrap());
+        fs::write(&in_path, "hello").unwrap();
+        cache.save("somekey", &in_path).unwrap();
+        assert!(cache.restore("somekey", &out_path).unwrap());
+        assert_eq!(fs::read_to_string(&out_path).unwrap(), "hello");
+
+        // No temporary files are left behind.
+        let names: Vec<_> = fs::read_dir(vfs.join("cache")).unwrap().collect();
+        assert_eq!(
+            names.len(),
+            2,
+            "expected only the version file and the entry"
+        );
+
+        let cache = Cache::open(&vfs, false).unwrap();
+        assert!(!cache.restore("somekey", &out_path).unwrap());
+    }
+
+    #[test]
+    fn test_cache_version_mismatch() {
+        let vfs = make_tmp_vfs();
+        let cache = Cache::open(&vfs, true).unwrap();
+        let in_path = vfs.join("in");
+        fs::write(&in_path, "hello").unwrap();
+        cache.save("somekey", &in_path).unwrap();
+
+        fs::write(vfs.join("cache").join(VERSION_FILE), "0.0.0").unwrap();
+        let cache = Cache::open(&vfs, true).unwrap();
+        assert!(!cache.restore("somekey", &vfs.join("out")).unwrap());
+        let version = fs::read_to_string(vfs.join("cache").join(VERSION_FILE)).unwrap();
+        assert_eq!(version, VERSION);
+    }
+
+    #[test]
+    fn test_make_key() {
+        let dir = make_tmp_dir();
+        let path = dir.join("img.png");
+        fs::write(&path, "hello").unwrap();
+        let mut file_config = FileConfig {
+            path: path.clone(),
+            ..Default::default()
+        };
+        let key1 = make_key(&path, &file_config).unwrap();
+        let key2 = make_key(&path, &file_config).unwrap();
+        assert_eq!(key1, key2, "not idempotent");
+
+        fs::write(&path, "hell").unwrap();
+        let key3 = make_key(&path, &file_config).unwrap();
+        assert!(key2 != key3, "doesn't change if file changed");
+
+        file_config.copy = true;
+        let key4 = make_key(&path, &file_config).unwrap();
+        assert!(key3 != key4, "doesn't change if params changed");
+    }
+
+    #[test]
+    fn test_make_atlas_key() {
+        let root = make_tmp_dir();
+        fs::write(root.join("a.png"), "hello").unwrap();
+        let raw = "app_id = \"snek\"\napp_name = \"Snek\"\n";
+        let mut config: Config = toml::from_str(raw).unwrap();
+        config.root_path.clone_from(&root);
+        let mut atlas_config = AtlasConfig {
+            files: vec![PathBuf::from("a.png")],
+            width: None,
+        };
+        let key1 = make_atlas_key(&config, &atlas_config).unwrap();
+        assert_eq!(key1, make_atlas_key(&config, &atlas_config).unwrap());
+
+        fs::write(root.join("a.png"), "hell").unwrap();
+        let key2 = make_atlas_key(&config, &atlas_config).unwrap();
+        assert!(key1 != key2, "doesn't change if file changed");
+
+        atlas_config.width = Some(64);
+        let key3 = make_atlas_key(&config, &atlas_config).unwrap();
+        assert!(key2 != key3, "doesn't change if params changed");
+    }
+}
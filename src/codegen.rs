@@ -0,0 +1,229 @@
+use crate::config::{CodegenConfig, Config, Lang};
+use crate::langs::detect_lang;
+use anyhow::{bail, Context};
+use std::collections::HashSet;
+use std::fmt::Write;
+use std::fs;
+
+/// The first line of every generated file.
+const HEADER: &str = "// Code generated by firefly_cli. DO NOT EDIT.";
+
+/// Generate a source file with a constant for every ROM file produced from firefly.toml.
+pub fn write_codegen(config: &Config, codegen: &CodegenConfig) -> anyhow::Result<()> {
+    let lang: Lang = match &codegen.lang {
+        Some(lang) => lang.clone(),
+        None => match &config.lang {
+            Some(lang) => lang.clone(),
+            None => detect_lang(&config.root_path)?,
+        },
+    };
+    let names = collect_names(config);
+    let names: Vec<&str> = names.iter().map(String::as_str).collect();
+    let source = match lang {
+        Lang::Go => {
+            let package = codegen.package.as_deref().unwrap_or("main");
+            generate_go(package, &names)?
+        }
+        Lang::Rust => generate_rust(&names)?,
+        _ => bail!("code generation is not supported for {lang:?}"),
+    };
+    let output_path = config.root_path.join(&codegen.path);
+    // Don't touch the file if nothing changed to avoid triggering recompilation.
+    if let Ok(old_source) = fs::read_to_string(&output_path) {
+        if old_source == source {
+            return Ok(());
+        }
+    }
+    if let Some(parent) = output_path.parent() {
+        fs::create_dir_all(parent).context("create output dir")?;
+    }
+    fs::write(output_path, source).context("write generated file")?;
+    Ok(())
+}
+
+/// Get names of all ROM files that build writes for `files` and `atlases`.
+fn collect_names(config: &Config) -> Vec<String> {
+    let mut names = Vec::new();
+    if let Some(files) = &config.files {
+        for (name, file_config) in files {
+            names.push(name.clone());
+            if !file_config.animations.is_empty() {
+                names.push(format!("{name}.anim"));
+            }
+        }
+    }
+    if let Some(atlases) = &config.atlases {
+        for name in atlases.keys() {
+            names.push(name.clone());
+            names.push(format!("{name}.atlas"));
+        }
+    }
+    // The reserved files (like `_bin`) aren't loaded by the app.
+    names.retain(|name| !name.starts_with('_'));
+    names.sort_unstable();
+    names
+}
+
+fn generate_go(package: &str, names: &[&str]) -> anyhow::Result<String> {
+    let mut source = format!("{HEADER}\n\npackage {package}\n");
+    if names.is_empty() {
+        return Ok(source);
+    }
+    source.push_str("\n// Names of the files declared in firefly.toml.\nconst (\n");
+    let mut seen = HashSet::new();
+    for name in names {
+        let ident = to_camel_case(name);
+        if !seen.insert(ident.clone()) {
+            bail!("file name \"{name}\" produces a duplicate constant {ident}");
+        }
+        _ = writeln!(source, "\t{ident} = {}", quote_go(name));
+    }
+    source.push_str(")\n");
+    Ok(source)
+}
+
+fn generate_rust(names: &[&str]) -> anyhow::Result<String> {
+    let mut source = format!("{HEADER}\n");
+    if !names.is_empty() {
+        source.push_str("\n// Names of the files declared in firefly.toml.\n");
+    }
+    let mut seen = HashSet::new();
+    for name in names {
+        let ident = to_screaming_snake_case(name);
+        if !seen.insert(ident.clone()) {
+            bail!("file name \"{name}\" produces a duplicate constant {ident}");
+        }
+        _ = writeln!(source, "pub const {ident}: &str = {name:?};");
+    }
+    Ok(source)
+}
+
+/// Make a Go string literal, escaping the same way as `strconv.Quote`.
+fn quote_go(s: &str) -> String {
+    let mut quoted = String::from('"');
+    for c in s.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\x07' => quoted.push_str("\\a"),
+            '\x08' => quoted.push_str("\\b"),
+            '\x0c' => quoted.push_str("\\f"),
+            '\n' => quoted.push_str("\\n"),
+            '\r' => quoted.push_str("\\r"),
+            '\t' => quoted.push_str("\\t"),
+            '\x0b' => quoted.push_str("\\v"),
+            c if u32::from(c) < 0x80 && c.is_control() => {
+                _ = write!(quoted, "\\x{:02x}", u32::from(c));
+            }
+            // All other control characters are below U+FFFF.
+            c if c.is_control() => _ = write!(quoted, "\\u{:04x}", u32::from(c)),
+            c => quoted.push(c),
+        }
+    }
+    quoted.push('"');
+    quoted
+}
+
+/// Convert a file name into an exported Go identifier: `hero-idle.png` -> `HeroIdlePng`.
+fn to_camel_case(name: &str) -> String {
+    let mut ident = String::new();
+    for word in name.split(|c: char| !c.is_ascii_alphanumeric()) {
+        let mut chars = word.chars();
+        if let Some(first) = chars.next() {
+            ident.push(first.to_ascii_uppercase());
+            ident.push_str(chars.as_str());
+        }
+    }
+    if !ident.starts_with(|c: char| c.is_ascii_alphabetic()) {
+        ident.insert_str(0, "File");
+    }
+    ident
+}
+
+/// Convert a file name into a Rust constant name: `hero-idle.png` -> `HERO_IDLE_PNG`.
+fn to_screaming_snake_case(name: &str) -> String {
+    let words: Vec<_> = name
+        .split(|c: char| !c.is_ascii_alphanumeric())
+        .filter(|word| !word.is_empty())
+        .map(str::to_ascii_uppercase)
+        .collect();
+    let ident = words.join("_");
+    if ident.starts_with(|c: char| c.is_ascii_alphabetic()) {
+        ident
+    } else {
+        format!("FILE_{ident}")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_to_camel_case() {
+        assert_eq!(to_camel_case("font"), "Font");
+        assert_eq!(to_camel_case("hero-idle.png"), "HeroIdlePng");
+        assert_eq!(to_camel_case("level_1"), "Level1");
+        assert_eq!(to_camel_case("1up"), "File1up");
+        assert_eq!(to_camel_case(""), "File");
+    }
+
+    #[test]
+    fn test_to_screaming_snake_case() {
+        assert_eq!(to_screaming_snake_case("font"), "FONT");
+        assert_eq!(to_screaming_snake_case("hero-idle.png"), "HERO_IDLE_PNG");
+        assert_eq!(to_screaming_snake_case("level__1"), "LEVEL_1");
+        assert_eq!(to_screaming_snake_case("1up"), "FILE_1UP");
+    }
+
+    #[test]
+    fn test_quote_go() {
+        assert_eq!(quote_go("font"), r#""font""#);
+        assert_eq!(quote_go("a\"b\\c"), r#""a\"b\\c""#);
+        assert_eq!(quote_go("a\nb\tc"), r#""a\nb\tc""#);
+        assert_eq!(quote_go("a\x01\x7f"), r#""a\x01\x7f""#);
+        assert_eq!(quote_go("a\u{85}"), r#""a\u0085""#);
+        assert_eq!(quote_go("héro"), r#""héro""#);
+    }
+
+    #[test]
+    fn test_collect_names() {
+        let raw = r#"
+author_id = "greg"
+app_id = "snek"
+author_name = "Greg"
+app_name = "Snek"
+
+[files]
+_bin = { path = "main.wasm" }
+font = { path = "font.fff" }
+hero = { path = "hero.png", animations = { walk = { frames = [0], duration = 100 } } }
+
+[atlases]
+ui = { files = ["button.png"] }
+"#;
+        let config: Config = toml::from_str(raw).unwrap();
+        let names = collect_names(&config);
+        let expected = ["font", "hero", "hero.anim", "ui", "ui.atlas"];
+        assert_eq!(names, expected);
+    }
+
+    #[test]
+    fn test_generate_go() {
+        let source = generate_go("assets", &["font", "hero-idle"]).unwrap();
+        assert!(source.starts_with(HEADER));
+        assert!(source.contains("package assets\n"));
+        assert!(source.contains("\tFont = \"font\"\n"));
+        assert!(source.contains("\tHeroIdle = \"hero-idle\"\n"));
+        assert!(generate_go("main", &["a-b", "a_b"]).is_err());
+    }
+
+    #[test]
+    fn test_generate_rust() {
+        let source = generate_rust(&["font", "hero-idle"]).unwrap();
+        assert!(source.starts_with(HEADER));
+        assert!(source.contains("pub const FONT: &str = \"font\";\n"));
+        assert!(source.contains("pub const HERO_IDLE: &str = \"hero-idle\";\n"));
+        assert!(generate_rust(&["a-b", "a_b"]).is_err());
+    }
+}
@@ -1,17 +1,90 @@
-use anyhow::Context;
-use serde::Deserialize;
-use std::collections::HashMap;
+use crate::new::make_name;
+use crate::settings::Settings;
+use anyhow::{bail, Context};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::fmt::Write as _;
 use std::fs;
 use std::path::{Path, PathBuf};
 
+/// All top-level keys of firefly.toml. Must be kept in sync with [`Config`].
+const CONFIG_KEYS: &[&str] = &[
+    "app_id",
+    "author_id",
+    "app_name",
+    "author_name",
+    "description",
+    "locales",
+    "version",
+    "lang",
+    "compile_args",
+    "launcher",
+    "sudo",
+    "remap",
+    "files",
+    "badges",
+    "boards",
+    "atlases",
+    "codegen",
+    "build",
+];
+
+/// Keys of nested sections of firefly.toml. Used only for hints.
+const NESTED_KEYS: &[&str] = &[
+    "path",
+    "url",
+    "sha256",
+    "copy",
+    "frames",
+    "dither",
+    "bpp",
+    "slice",
+    "tile_width",
+    "tile_height",
+    "count",
+    "width",
+    "id",
+    "name",
+    "hidden",
+    "title",
+    "direction",
+    "package",
+    "max_width",
+    "max_height",
+    "allow_odd_size",
+    "transparent_color",
+    "tiles",
+    "layer",
+    "animations",
+    "duration",
+    "durations",
+    "loop",
+    "optimize",
+    "strip",
+    "max_size",
+    "check_imports",
+];
+
 #[derive(Deserialize, Debug)]
 #[serde(deny_unknown_fields)]
 pub struct Config {
-    pub app_id:      String,
-    pub author_id:   String,
-    pub app_name:    String,
+    pub app_id:   String,
+    pub app_name: String,
+
+    /// If not specified, the default author from the user settings is used.
+    #[serde(default)]
+    pub author_id: String,
+
+    /// If not specified, generated from `author_id`.
+    #[serde(default)]
     pub author_name: String,
 
+    /// Short description of the app. Used as the default for all locales.
+    pub description: Option<String>,
+
+    /// Translations of the app name and description, keyed by ISO 639-1 language code.
+    pub locales: Option<BTreeMap<String, LocaleConfig>>,
+
     /// The app version. Compared between devices when starting multiplayer.
     #[serde(default)]
     pub version: Option<u32>,
@@ -30,9 +103,35 @@ pub struct Config {
     #[serde(default)]
     pub sudo: bool,
 
+    /// Replace colors from a custom palette by the default palette colors.
+    ///
+    /// The keys are hex colors (like "#FF0000") and the values are palette indices.
+    /// Applied to all images, individual files can override it.
+    pub remap: Option<BTreeMap<String, u8>>,
+
     /// Mapping of local files to be included into the ROM.
     pub files: Option<HashMap<String, FileConfig>>,
 
+    /// Badges (achievements) that the player can earn.
+    pub badges: Option<Vec<BadgeConfig>>,
+
+    /// Scoreboards (leaderboards) for the player scores.
+    pub boards: Option<Vec<BoardConfig>>,
+
+    /// Groups of images to be packed into a single image.
+    pub atlases: Option<HashMap<String, AtlasConfig>>,
+
+    /// Generate a source file with constants for all file names.
+    pub codegen: Option<CodegenConfig>,
+
+    /// Post-processing of the wasm binary.
+    #[serde(default)]
+    pub build: BuildConfig,
+
+    /// Top-level keys that were ignored because they are unknown.
+    #[serde(skip)]
+    pub unknown_keys: Vec<String>,
+
     /// Path to the project root.
     #[serde(skip)]
     pub root_path: PathBuf,
@@ -47,15 +146,52 @@ pub struct Config {
 }
 
 impl Config {
-    pub fn load(vfs: PathBuf, root: &Path) -> anyhow::Result<Self> {
+    /// Read and parse firefly.toml from the project root.
+    ///
+    /// Unknown top-level keys are an error unless `allow_unknown` is set,
+    /// in which case they are ignored and listed in `unknown_keys`.
+    pub fn load(vfs: PathBuf, root: &Path, allow_unknown: bool) -> anyhow::Result<Self> {
+        Self::load_with(vfs, root, allow_unknown, None, Settings::load)
+    }
+
+    /// Like [`Config::load`] but with the author ID from the CLI flag
+    /// and a custom source of the user-level settings.
+    ///
+    /// The settings are loaded only if the author ID is set neither
+    /// by the flag nor in firefly.toml.
+    pub fn load_with<F>(
+        vfs: PathBuf,
+        root: &Path,
+        allow_unknown: bool,
+        author: Option<&str>,
+        settings: F,
+    ) -> anyhow::Result<Self>
+    where
+        F: FnOnce() -> anyhow::Result<Settings>,
+    {
         let config_path = root.join("firefly.toml");
         let raw_config = fs::read_to_string(config_path).context("read config file")?;
-        let mut config: Self = toml::from_str(raw_config.as_str()).context("parse config")?;
+        let mut config = if allow_unknown {
+            parse_lenient(&raw_config)?
+        } else {
+            match toml::from_str::<Self>(&raw_config) {
+                Ok(config) => config,
+                Err(err) => bail!("parse config: {}{}", err, make_hint(err.message())),
+            }
+        };
         config.root_path = match std::env::current_dir() {
             // Make the path absolute if possible
             Ok(current_dir) => current_dir.join(root),
             Err(_) => PathBuf::from(root),
         };
+        if let (Some(remap), Some(files)) = (&config.remap, &mut config.files) {
+            for file_config in files.values_mut() {
+                for (color, index) in remap {
+                    file_config.remap.entry(color.clone()).or_insert(*index);
+                }
+            }
+        }
+        config.resolve_author(author, settings)?;
         config.vfs_path = vfs;
         config.rom_path = config
             .vfs_path
@@ -66,7 +202,89 @@ impl Config {
     }
 }
 
-#[derive(Deserialize, Debug)]
+impl Config {
+    /// Fill the author ID and name if they are not specified in firefly.toml.
+    ///
+    /// The priority is: the CLI flag, firefly.toml, the user-level settings.
+    fn resolve_author<F>(&mut self, author: Option<&str>, settings: F) -> anyhow::Result<()>
+    where
+        F: FnOnce() -> anyhow::Result<Settings>,
+    {
+        if let Some(author) = author {
+            author.clone_into(&mut self.author_id);
+        }
+        if self.author_id.is_empty() {
+            let Some(author) = settings()?.author else {
+                bail!(
+                    "author_id is not set: pass --author, add it to firefly.toml, \
+                    or set the default author with `firefly_cli config set author <ID>`"
+                );
+            };
+            self.author_id = author;
+        }
+        if self.author_name.is_empty() {
+            self.author_name = make_name(&self.author_id);
+        }
+        Ok(())
+    }
+}
+
+/// Parse the config, ignoring unknown top-level keys.
+fn parse_lenient(raw_config: &str) -> anyhow::Result<Config> {
+    let mut table: toml::Table = toml::from_str(raw_config).context("parse config")?;
+    let mut unknown_keys = Vec::new();
+    table.retain(|key, _| {
+        let known = CONFIG_KEYS.contains(&key);
+        if !known {
+            unknown_keys.push(key.to_string());
+        }
+        known
+    });
+    let mut config: Config = match table.try_into() {
+        Ok(config) => config,
+        Err(err) => bail!("parse config: {}{}", err, make_hint(err.message())),
+    };
+    config.unknown_keys = unknown_keys;
+    Ok(config)
+}
+
+/// Make a short hint for the config parsing error.
+fn make_hint(message: &str) -> String {
+    let Some(rest) = message.strip_prefix("unknown field `") else {
+        return String::new();
+    };
+    let Some((field, _)) = rest.split_once('`') else {
+        return String::new();
+    };
+    let mut hint = String::from("\n💡 ");
+    let known = CONFIG_KEYS.iter().chain(NESTED_KEYS);
+    let closest = known
+        .map(|key| (edit_distance(field, key), key))
+        .filter(|(distance, _)| *distance <= 2)
+        .min();
+    if let Some((_, key)) = closest {
+        _ = write!(hint, "did you mean `{key}`? ");
+    }
+    hint.push_str("Use --allow-unknown to ignore unknown top-level keys.");
+    hint
+}
+
+/// The Levenshtein distance between two strings.
+fn edit_distance(a: &str, b: &str) -> usize {
+    let b: Vec<char> = b.chars().collect();
+    let mut prev: Vec<usize> = (0..=b.len()).collect();
+    for (i, ca) in a.chars().enumerate() {
+        let mut curr = vec![i + 1; b.len() + 1];
+        for (j, cb) in b.iter().enumerate() {
+            let cost = usize::from(ca != *cb);
+            curr[j + 1] = (prev[j] + cost).min(prev[j + 1] + 1).min(curr[j] + 1);
+        }
+        prev = curr;
+    }
+    prev[b.len()]
+}
+
+#[derive(Deserialize, Debug, Default)]
 #[serde(deny_unknown_fields)]
 pub struct FileConfig {
     /// Path to the file relative to the project root.
@@ -81,9 +299,205 @@ pub struct FileConfig {
     /// If the file should be copied as-is, without any processing.
     #[serde(default)]
     pub copy: bool,
+
+    /// What to do with multiple frames in an Aseprite file.
+    #[serde(default)]
+    pub frames: Frames,
+
+    /// How to map image colors not present in the palette.
+    #[serde(default)]
+    pub dither: Dither,
+
+    /// Bits per pixel for images: 1, 2, or 4.
+    ///
+    /// If not specified, the smallest one that fits all colors of the image is used.
+    pub bpp: Option<u8>,
+
+    /// The color (in the #RRGGBB format) to treat as transparent, in addition to alpha.
+    pub transparent_color: Option<String>,
+
+    /// Replace colors from a custom palette by the default palette colors.
+    #[serde(default)]
+    pub remap: BTreeMap<String, u8>,
+
+    /// The maximum image (or tile, if sliced) width. Defaults to the screen width.
+    pub max_width: Option<u32>,
+
+    /// The maximum image (or tile, if sliced) height. Defaults to the screen height.
+    pub max_height: Option<u32>,
+
+    /// Don't warn if the image height is not a multiple of 8.
+    #[serde(default)]
+    pub allow_odd_size: bool,
+
+    /// Cut the image into equally-sized tiles and put them into a single row.
+    pub slice: Option<SliceConfig>,
+
+    /// Named sequences of frames of the sliced image.
+    ///
+    /// Written into the ROM as a separate file with `.anim` added to the file name.
+    #[serde(default)]
+    pub animations: BTreeMap<String, AnimationConfig>,
+
+    /// The number of tiles in the tileset used by the tilemap.
+    pub tiles: Option<u16>,
+
+    /// The name of the tilemap layer to use if the TMX file has multiple layers.
+    pub layer: Option<String>,
+}
+
+#[derive(Deserialize, Debug, Default)]
+#[serde(rename_all = "kebab-case")]
+pub enum Dither {
+    /// All colors must be exactly from the palette.
+    #[default]
+    None,
+
+    /// Map every color to the nearest palette color and diffuse the error.
+    FloydSteinberg,
+}
+
+#[derive(Deserialize, Debug, Default)]
+#[serde(rename_all = "lowercase")]
+pub enum Frames {
+    /// Use only the first frame.
+    #[default]
+    Flatten,
+
+    /// Put all frames side by side into a spritesheet.
+    Split,
+}
+
+#[derive(Deserialize, Debug, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct LocaleConfig {
+    /// The translated app name. Defaults to `app_name`.
+    pub app_name: Option<String>,
+
+    /// The translated app description. Defaults to `description`.
+    pub description: Option<String>,
+}
+
+#[derive(Deserialize, Serialize, Debug, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct BadgeConfig {
+    /// The unique ID of the badge used by the app code.
+    pub id: String,
+
+    /// The human-readable name of the badge.
+    pub name: String,
+
+    #[serde(default)]
+    pub description: String,
+
+    /// Don't show the badge to the player until it's earned.
+    #[serde(default)]
+    pub hidden: bool,
+}
+
+#[derive(Deserialize, Serialize, Debug, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct BoardConfig {
+    /// The unique ID of the board used by the app code.
+    pub id: String,
+
+    /// The human-readable name of the board.
+    pub title: String,
+
+    /// How to sort the scores.
+    #[serde(default)]
+    pub direction: Direction,
+}
+
+#[derive(Deserialize, Serialize, Debug, Default, PartialEq, Eq, Clone, Copy)]
+#[serde(rename_all = "kebab-case")]
+pub enum Direction {
+    /// Bigger score is better. For example, points.
+    #[default]
+    HigherIsBetter,
+
+    /// Smaller score is better. For example, time to finish a level.
+    LowerIsBetter,
+}
+
+#[derive(Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct AtlasConfig {
+    /// Paths to the images relative to the project root.
+    ///
+    /// The file name without the extension is used as the region name.
+    pub files: Vec<PathBuf>,
+
+    /// The maximum width of the atlas image. Defaults to 128.
+    pub width: Option<u32>,
+}
+
+#[derive(Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct SliceConfig {
+    pub tile_width:  u32,
+    pub tile_height: u32,
+
+    /// How many tiles to take. Defaults to all tiles in the image.
+    pub count: Option<u32>,
+}
+
+#[derive(Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct AnimationConfig {
+    /// Indices of the tiles (frames) in the order they are played.
+    pub frames: Vec<u16>,
+
+    /// How long to show each frame, in milliseconds.
+    pub duration: Option<u16>,
+
+    /// How long to show each frame, individually for every frame. Overrides `duration`.
+    pub durations: Option<Vec<u16>>,
+
+    /// Start from the first frame when the animation ends.
+    #[serde(default, rename = "loop")]
+    pub looped: bool,
+}
+
+#[derive(Deserialize, Debug, Default)]
+#[serde(deny_unknown_fields)]
+pub struct BuildConfig {
+    /// Run wasm-opt to reduce the binary size. Enabled by default.
+    pub optimize: Option<bool>,
+
+    /// Remove debug info and custom sections, including function names.
+    /// Enabled by default. Disable to get readable stack traces.
+    pub strip: Option<bool>,
+
+    /// Fail the build if the ROM is bigger than that. Bytes or a string like "64KB".
+    pub max_size: Option<SizeConfig>,
+
+    /// Fail the build if the binary imports functions not provided by the runtime.
+    /// Enabled by default. Disable if the runtime is newer than this CLI.
+    pub check_imports: Option<bool>,
+}
+
+#[derive(Deserialize, Debug)]
+#[serde(untagged)]
+pub enum SizeConfig {
+    Bytes(u64),
+    Text(String),
+}
+
+#[derive(Deserialize, Debug)]
+#[serde(deny_unknown_fields)]
+pub struct CodegenConfig {
+    /// Path to the generated file relative to the project root.
+    pub path: PathBuf,
+
+    /// The language of the generated file. Defaults to the language of the app.
+    pub lang: Option<Lang>,
+
+    /// The package name for the generated Go file. Defaults to "main".
+    pub package: Option<String>,
 }
 
-#[derive(Deserialize, Debug, Clone)]
+#[derive(Deserialize, Debug, Clone, PartialEq, Eq, clap::ValueEnum)]
 #[serde(rename_all = "lowercase")]
 pub enum Lang {
     Go,
@@ -94,3 +508,140 @@ pub enum Lang {
     Cpp,
     Python,
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    const MINIMAL: &str = r#"
+author_id = "greg"
+app_id = "snek"
+author_name = "Greg"
+app_name = "Snek"
+"#;
+
+    /// Load the config without reading the user-level settings.
+    fn load_hermetic(root: &Path, allow_unknown: bool) -> anyhow::Result<Config> {
+        Config::load_with(make_tmp_vfs(), root, allow_unknown, None, || {
+            panic!("settings must not be loaded")
+        })
+    }
+
+    #[test]
+    fn test_load_strict() {
+        let root = make_tmp_dir();
+        let raw = format!("{MINIMAL}app_nmae = \"Snake\"\n");
+        fs::write(root.join("firefly.toml"), raw).unwrap();
+        let err = load_hermetic(&root, false).unwrap_err();
+        let msg = err.to_string();
+        assert!(msg.contains("line 6"), "{msg}");
+        assert!(msg.contains("unknown field `app_nmae`"), "{msg}");
+        assert!(msg.contains("did you mean `app_name`?"), "{msg}");
+
+        let config = load_hermetic(&root, true).unwrap();
+        assert_eq!(config.app_name, "Snek");
+        assert_eq!(config.unknown_keys, vec!["app_nmae"]);
+    }
+
+    #[test]
+    fn test_load_missing_field() {
+        let root = make_tmp_dir();
+        let raw = MINIMAL.replace("app_name = \"Snek\"", "");
+        fs::write(root.join("firefly.toml"), raw).unwrap();
+        let err = load_hermetic(&root, false).unwrap_err();
+        assert!(err.to_string().contains("missing field `app_name`"));
+    }
+
+    #[test]
+    fn test_resolve_author() {
+        let raw = MINIMAL.replace("author_id = \"greg\"", "");
+        let raw = raw.replace("author_name = \"Greg\"", "");
+        let settings = || {
+            Ok(Settings {
+                author: Some("joe-arms".to_string()),
+            })
+        };
+        let mut config: Config = toml::from_str(&raw).unwrap();
+        let err = config
+            .resolve_author(None, || Ok(Settings::default()))
+            .unwrap_err();
+        assert!(err.to_string().contains("config set author"));
+
+        config.resolve_author(None, settings).unwrap();
+        assert_eq!(config.author_id, "joe-arms");
+        assert_eq!(config.author_name, "Joe Arms");
+
+        let mut config: Config = toml::from_str(&raw).unwrap();
+        config.resolve_author(Some("ann"), settings).unwrap();
+        assert_eq!(config.author_id, "ann");
+
+        let mut config: Config = toml::from_str(MINIMAL).unwrap();
+        config
+            .resolve_author(None, || panic!("settings must not be loaded"))
+            .unwrap();
+        assert_eq!(config.author_id, "greg");
+        config.resolve_author(Some("ann"), settings).unwrap();
+        assert_eq!(config.author_id, "ann");
+    }
+
+    /// A deserializer that only records the names of the struct fields.
+    struct FieldNames<'a>(&'a mut &'static [&'static str]);
+
+    impl<'de> serde::Deserializer<'de> for FieldNames<'_> {
+        type Error = serde::de::value::Error;
+
+        serde::forward_to_deserialize_any! {
+            bool i8 i16 i32 i64 i128 u8 u16 u32 u64 u128 f32 f64 char str string
+            bytes byte_buf option unit unit_struct newtype_struct seq tuple
+            tuple_struct map enum identifier ignored_any
+        }
+
+        fn deserialize_any<V: serde::de::Visitor<'de>>(
+            self,
+            _: V,
+        ) -> Result<V::Value, Self::Error> {
+            Err(serde::de::Error::custom("not a struct"))
+        }
+
+        fn deserialize_struct<V: serde::de::Visitor<'de>>(
+            self,
+            _name: &'static str,
+            fields: &'static [&'static str],
+            _visitor: V,
+        ) -> Result<V::Value, Self::Error> {
+            *self.0 = fields;
+            Err(serde::de::Error::custom("done"))
+        }
+    }
+
+    #[test]
+    fn test_config_keys() {
+        let mut fields: &[&str] = &[];
+        _ = Config::deserialize(FieldNames(&mut fields));
+        assert!(!fields.is_empty());
+        for field in fields {
+            assert!(CONFIG_KEYS.contains(field), "{field} is not in CONFIG_KEYS");
+        }
+        for key in CONFIG_KEYS {
+            assert!(fields.contains(key), "{key} is not a Config field");
+        }
+    }
+
+    #[test]
+    fn test_make_hint() {
+        assert_eq!(make_hint("invalid type"), "");
+        let hint = make_hint("unknown field `lnag`, expected one of `lang`");
+        assert!(hint.contains("did you mean `lang`?"));
+        let hint = make_hint("unknown field `something`, expected one of `lang`");
+        assert!(!hint.contains("did you mean"));
+    }
+
+    #[test]
+    fn test_edit_distance() {
+        assert_eq!(edit_distance("lang", "lang"), 0);
+        assert_eq!(edit_distance("lnag", "lang"), 2);
+        assert_eq!(edit_distance("app_nam", "app_name"), 1);
+        assert_eq!(edit_distance("", "abc"), 3);
+    }
+}
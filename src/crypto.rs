@@ -1,5 +1,6 @@
 use crate::file_names::{HASH, SIG};
 use anyhow::{bail, Context};
+use data_encoding::HEXLOWER;
 use sha2::digest::consts::U32;
 use sha2::digest::generic_array::GenericArray;
 use sha2::{Digest, Sha256};
@@ -35,6 +36,20 @@ pub fn hash_dir(rom_path: &Path) -> anyhow::Result<GenericArray<u8, U32>> {
     Ok(hash)
 }
 
+/// Short human-readable identifier of the public key.
+///
+/// It's the first 8 bytes of SHA256 of the DER-encoded key.
+pub fn fingerprint(pub_key_raw: &[u8]) -> String {
+    let hash = Sha256::digest(pub_key_raw);
+    let hex = HEXLOWER.encode(&hash[..8]);
+    let parts: Vec<_> = hex
+        .as_bytes()
+        .chunks(4)
+        .map(String::from_utf8_lossy)
+        .collect();
+    parts.join(":")
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -57,4 +72,12 @@ mod tests {
         let hash4: &[u8] = &hash_dir(&dir).unwrap();
         assert!(hash3 != hash4, "doesn't change if fiels added");
     }
+
+    #[test]
+    fn test_fingerprint() {
+        let fp1 = fingerprint(b"hello");
+        assert_eq!(fp1, "2cf2:4dba:5fb0:a30e");
+        let fp2 = fingerprint(b"hell");
+        assert!(fp1 != fp2);
+    }
 }
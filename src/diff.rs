@@ -0,0 +1,232 @@
+use crate::args::DiffArgs;
+use crate::file_names::{BIN, META};
+use crate::output::{is_json, print_json};
+use crate::rom::open_rom;
+use anyhow::{Context, Result};
+use colored::Colorize;
+use firefly_meta::Meta;
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+
+/// What changed between two ROMs.
+#[derive(Serialize, Default)]
+struct RomDiff {
+    /// Meta fields that differ: name, old value, new value.
+    meta:     Vec<(String, String, String)>,
+    added:    Vec<String>,
+    removed:  Vec<String>,
+    changed:  Vec<String>,
+    /// The wasm binary sizes.
+    old_bin:  u64,
+    new_bin:  u64,
+    #[serde(skip)]
+    old_size: u64,
+    #[serde(skip)]
+    new_size: u64,
+}
+
+impl RomDiff {
+    const fn is_empty(&self) -> bool {
+        self.meta.is_empty()
+            && self.added.is_empty()
+            && self.removed.is_empty()
+            && self.changed.is_empty()
+    }
+}
+
+/// Size and SHA256 hash of a ROM file.
+struct FileInfo {
+    size: u64,
+    hash: Vec<u8>,
+}
+
+pub fn cmd_diff(vfs: &Path, args: &DiffArgs) -> Result<()> {
+    let old_rom = open_rom(vfs, &args.old).context("open old ROM")?;
+    let new_rom = open_rom(vfs, &args.new).context("open new ROM")?;
+    let diff = diff_dirs(&old_rom.path, &new_rom.path)?;
+    if is_json() {
+        print_json(&diff)?;
+    } else {
+        print_diff(&diff);
+    }
+    Ok(())
+}
+
+fn diff_dirs(old_path: &Path, new_path: &Path) -> Result<RomDiff> {
+    let mut diff = RomDiff {
+        meta: diff_meta(old_path, new_path)?,
+        ..RomDiff::default()
+    };
+    let old_files = collect_files(old_path).context("read old ROM")?;
+    let new_files = collect_files(new_path).context("read new ROM")?;
+    for (name, old_file) in &old_files {
+        match new_files.get(name) {
+            Some(new_file) if new_file.hash != old_file.hash => diff.changed.push(name.clone()),
+            Some(_) => {}
+            None => diff.removed.push(name.clone()),
+        }
+    }
+    for name in new_files.keys() {
+        if !old_files.contains_key(name) {
+            diff.added.push(name.clone());
+        }
+    }
+    diff.old_bin = old_files.get(BIN).map_or(0, |f| f.size);
+    diff.new_bin = new_files.get(BIN).map_or(0, |f| f.size);
+    diff.old_size = old_files.values().map(|f| f.size).sum();
+    diff.new_size = new_files.values().map(|f| f.size).sum();
+    Ok(diff)
+}
+
+fn diff_meta(old_path: &Path, new_path: &Path) -> Result<Vec<(String, String, String)>> {
+    let old_raw = fs::read(old_path.join(META)).context("read old meta")?;
+    let new_raw = fs::read(new_path.join(META)).context("read new meta")?;
+    let old = Meta::decode(&old_raw).context("parse old meta")?;
+    let new = Meta::decode(&new_raw).context("parse new meta")?;
+    let pairs = [
+        (
+            "author_id",
+            old.author_id.to_string(),
+            new.author_id.to_string(),
+        ),
+        ("app_id", old.app_id.to_string(), new.app_id.to_string()),
+        (
+            "author_name",
+            old.author_name.to_string(),
+            new.author_name.to_string(),
+        ),
+        (
+            "app_name",
+            old.app_name.to_string(),
+            new.app_name.to_string(),
+        ),
+        ("version", old.version.to_string(), new.version.to_string()),
+        (
+            "launcher",
+            old.launcher.to_string(),
+            new.launcher.to_string(),
+        ),
+        ("sudo", old.sudo.to_string(), new.sudo.to_string()),
+    ];
+    let changed = pairs
+        .into_iter()
+        .filter(|(_, old, new)| old != new)
+        .map(|(name, old, new)| (name.to_string(), old, new))
+        .collect();
+    Ok(changed)
+}
+
+fn collect_files(rom_path: &Path) -> Result<BTreeMap<String, FileInfo>> {
+    let mut files = BTreeMap::new();
+    for entry in fs::read_dir(rom_path).context("read ROM dir")? {
+        let entry = entry.context("read ROM entry")?;
+        let path = entry.path();
+        if !path.is_file() {
+            continue;
+        }
+        let raw = fs::read(&path).context("read file")?;
+        let info = FileInfo {
+            size: raw.len() as u64,
+            hash: Sha256::digest(&raw).to_vec(),
+        };
+        files.insert(entry.file_name().to_string_lossy().to_string(), info);
+    }
+    Ok(files)
+}
+
+fn print_diff(diff: &RomDiff) {
+    if diff.is_empty() {
+        println!("✅ the ROMs are identical");
+        return;
+    }
+    for (name, old, new) in &diff.meta {
+        println!("{} {name}: {old} -> {new}", "meta   ".yellow());
+    }
+    for name in &diff.added {
+        println!("{} {name}", "added  ".green());
+    }
+    for name in &diff.removed {
+        println!("{} {name}", "removed".red());
+    }
+    for name in &diff.changed {
+        println!("{} {name}", "changed".yellow());
+    }
+    println!(
+        "binary size: {} -> {} ({})",
+        diff.old_bin,
+        diff.new_bin,
+        format_delta(diff.old_bin, diff.new_bin)
+    );
+    println!(
+        "total size:  {} -> {} ({})",
+        diff.old_size,
+        diff.new_size,
+        format_delta(diff.old_size, diff.new_size)
+    );
+}
+
+fn format_delta(old: u64, new: u64) -> String {
+    if new >= old {
+        format!("+{}", new - old)
+    } else {
+        format!("-{}", old - new)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    fn write_meta(rom_path: &Path, version: u32) {
+        let meta = Meta {
+            app_id: "snek",
+            app_name: "Snek",
+            author_id: "greg",
+            author_name: "Greg",
+            launcher: false,
+            sudo: false,
+            version,
+        };
+        let mut buf = vec![0; meta.size()];
+        let encoded = meta.encode(&mut buf).unwrap();
+        fs::write(rom_path.join(META), encoded).unwrap();
+    }
+
+    #[test]
+    fn test_diff_dirs() {
+        let old_path = make_tmp_dir();
+        let new_path = make_tmp_dir();
+        write_meta(&old_path, 1);
+        write_meta(&new_path, 1);
+        fs::write(old_path.join(BIN), "hello").unwrap();
+        fs::write(new_path.join(BIN), "hello").unwrap();
+        let diff = diff_dirs(&old_path, &new_path).unwrap();
+        assert!(diff.is_empty());
+
+        write_meta(&new_path, 2);
+        fs::write(new_path.join(BIN), "hello world").unwrap();
+        fs::write(old_path.join("font"), "").unwrap();
+        fs::write(new_path.join("sprite"), "").unwrap();
+        let diff = diff_dirs(&old_path, &new_path).unwrap();
+        assert_eq!(
+            diff.meta,
+            vec![("version".to_string(), "1".to_string(), "2".to_string())]
+        );
+        assert_eq!(diff.added, vec!["sprite"]);
+        assert_eq!(diff.removed, vec!["font"]);
+        assert_eq!(diff.changed, vec![BIN, META]);
+        assert_eq!(diff.old_bin, 5);
+        assert_eq!(diff.new_bin, 11);
+    }
+
+    #[test]
+    fn test_format_delta() {
+        assert_eq!(format_delta(10, 15), "+5");
+        assert_eq!(format_delta(15, 10), "-5");
+        assert_eq!(format_delta(7, 7), "+0");
+    }
+}
@@ -0,0 +1,274 @@
+use crate::args::DoctorArgs;
+use crate::config::{Config, Lang};
+use crate::langs::{detect_lang, is_supported};
+use crate::output::{is_json, print_json, Reported};
+use anyhow::{bail, Result};
+use serde::Serialize;
+use std::path::Path;
+use std::process::Command;
+
+/// An external tool used when building the project.
+struct Tool {
+    name:        &'static str,
+    args:        &'static [&'static str],
+    min_version: Version,
+    /// If false, the build works without the tool but the result might be worse.
+    required:    bool,
+    install_url: &'static str,
+}
+
+type Version = (u32, u32, u32);
+
+// The minimal versions are the oldest ones that support everything the build uses.
+// If there is no such hard limit for a tool, it's the oldest version
+// the build was expected to work with when the check was added.
+
+/// 0.30 is the first tinygo release that supports Go 1.21.
+const TINYGO: Tool = Tool {
+    name:        "tinygo",
+    args:        &["version"],
+    min_version: (0, 30, 0),
+    required:    true,
+    install_url: "https://tinygo.org/getting-started/install/",
+};
+
+/// Go 1.21 introduced `//go:wasmimport` used to call the runtime.
+const GO: Tool = Tool {
+    name:        "go",
+    args:        &["version"],
+    min_version: (1, 21, 0),
+    required:    true,
+    install_url: "https://go.dev/doc/install",
+};
+
+/// No hard limit, bump it when the build starts to rely on a newer Rust.
+const CARGO: Tool = Tool {
+    name:        "cargo",
+    args:        &["--version"],
+    min_version: (1, 70, 0),
+    required:    true,
+    install_url: "https://rustup.rs/",
+};
+
+/// Zig 0.11 replaced `-Drelease-small` with `-Doptimize=ReleaseSmall` used by the build.
+const ZIG: Tool = Tool {
+    name:        "zig",
+    args:        &["version"],
+    min_version: (0, 11, 0),
+    required:    true,
+    install_url: "https://ziglang.org/download/",
+};
+
+/// No hard limit, binaryen versions are plain numbers and 100 is from 2021.
+const WASM_OPT: Tool = Tool {
+    name:        "wasm-opt",
+    args:        &["--version"],
+    min_version: (100, 0, 0),
+    required:    false,
+    install_url: "https://github.com/WebAssembly/binaryen/releases",
+};
+
+/// The compilation target that rustup must have installed to build Rust apps.
+const RUST_TARGET: &str = "wasm32-unknown-unknown";
+
+/// The result of checking a single tool.
+#[derive(Serialize)]
+struct Check {
+    name:     &'static str,
+    required: bool,
+    version:  Option<String>,
+    problem:  Option<String>,
+    install:  &'static str,
+}
+
+pub fn cmd_doctor(vfs: &Path, args: &DoctorArgs) -> Result<()> {
+    let lang = match &args.lang {
+        Some(lang) => lang.clone(),
+        None => find_lang(vfs, &args.root)?,
+    };
+    if !is_supported(&lang) {
+        bail!("{lang:?} is not supported by the build yet, there is nothing to check");
+    }
+    let mut checks: Vec<_> = tools_for(&lang).iter().map(check_tool).collect();
+    if matches!(lang, Lang::Rust) {
+        checks.push(check_rust_target());
+    }
+    if !is_json() {
+        print_checks(&checks);
+    }
+    let missing = checks
+        .iter()
+        .filter(|check| check.required && check.problem.is_some())
+        .count();
+    if is_json() {
+        print_json(&checks)?;
+    }
+    if missing > 0 {
+        let msg = format!("{missing} required tool(s) missing or outdated");
+        if is_json() {
+            return Err(Reported(msg).into());
+        }
+        bail!(msg);
+    }
+    Ok(())
+}
+
+/// Get the project language from firefly.toml or detect it from the project files.
+fn find_lang(vfs: &Path, root: &Path) -> Result<Lang> {
+    let config = Config::load(vfs.to_path_buf(), root, true).ok();
+    if let Some(lang) = config.and_then(|config| config.lang) {
+        return Ok(lang);
+    }
+    detect_lang(root)
+}
+
+/// The tools needed to build a project in the given language.
+fn tools_for(lang: &Lang) -> Vec<Tool> {
+    let mut tools = match lang {
+        Lang::Go => vec![TINYGO, GO],
+        Lang::Rust => vec![CARGO],
+        Lang::Zig | Lang::C | Lang::Cpp => vec![ZIG],
+        // Rejected by `is_supported` beforehand.
+        Lang::TS | Lang::Python => vec![],
+    };
+    tools.push(WASM_OPT);
+    tools
+}
+
+fn check_tool(tool: &Tool) -> Check {
+    let (version, problem) = match Command::new(tool.name).args(tool.args).output() {
+        Err(_) => (None, Some("not found in PATH".to_string())),
+        Ok(output) if !output.status.success() => {
+            (None, Some("installed but doesn't run".to_string()))
+        }
+        Ok(output) => {
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            match parse_version(&stdout) {
+                None => (None, Some("cannot detect the version".to_string())),
+                Some(version) if version < tool.min_version => {
+                    let (major, minor, patch) = tool.min_version;
+                    let problem = format!("outdated, need at least {major}.{minor}.{patch}");
+                    (Some(format_version(version)), Some(problem))
+                }
+                Some(version) => (Some(format_version(version)), None),
+            }
+        }
+    };
+    Check {
+        name: tool.name,
+        required: tool.required,
+        version,
+        problem,
+        install: tool.install_url,
+    }
+}
+
+/// Check that rustup has the wasm target installed.
+///
+/// Without rustup, the target might be installed in some other way,
+/// so the check is optional then.
+fn check_rust_target() -> Check {
+    let output = Command::new("rustup")
+        .args(["target", "list", "--installed"])
+        .output();
+    let (required, problem) = match output {
+        Err(_) => (false, Some("cannot check, rustup not found in PATH")),
+        Ok(output) if !output.status.success() => (false, Some("cannot check, rustup doesn't run")),
+        Ok(output) if has_target(&String::from_utf8_lossy(&output.stdout)) => (true, None),
+        Ok(_) => (true, Some("not installed")),
+    };
+    Check {
+        name: RUST_TARGET,
+        required,
+        version: None,
+        problem: problem.map(str::to_string),
+        install: "rustup target add wasm32-unknown-unknown",
+    }
+}
+
+/// Check if the wasm target is in the output of `rustup target list --installed`.
+fn has_target(output: &str) -> bool {
+    output.lines().any(|line| line.trim() == RUST_TARGET)
+}
+
+/// Find the first word in the output that looks like a version number.
+///
+/// Handles "go1.22.5", "v0.13.0", and "116" (for wasm-opt).
+fn parse_version(output: &str) -> Option<Version> {
+    for word in output.split_whitespace() {
+        let word = word.trim_start_matches("go").trim_start_matches('v');
+        if !word.starts_with(|c: char| c.is_ascii_digit()) {
+            continue;
+        }
+        let mut parts = word.split(['.', '-', '+']).map(str::parse::<u32>);
+        let Some(Ok(major)) = parts.next() else {
+            continue;
+        };
+        let minor = parts.next().and_then(Result::ok).unwrap_or(0);
+        let patch = parts.next().and_then(Result::ok).unwrap_or(0);
+        return Some((major, minor, patch));
+    }
+    None
+}
+
+fn format_version((major, minor, patch): Version) -> String {
+    format!("{major}.{minor}.{patch}")
+}
+
+fn print_checks(checks: &[Check]) {
+    for check in checks {
+        let version = check.version.as_deref().unwrap_or("");
+        match &check.problem {
+            None => println!("✅ {} {version}", check.name),
+            Some(problem) if check.required => {
+                println!("💥 {} {version}: {problem}", check.name);
+                println!("   💡 install it: {}", check.install);
+            }
+            Some(problem) => {
+                println!("⚠️  {} {version}: {problem} (optional)", check.name);
+                println!("   💡 install it: {}", check.install);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_version() {
+        let out = "tinygo version 0.33.0 linux/amd64 (using go version go1.22.5)";
+        assert_eq!(parse_version(out), Some((0, 33, 0)));
+        let out = "go version go1.22.5 linux/amd64";
+        assert_eq!(parse_version(out), Some((1, 22, 5)));
+        let out = "cargo 1.80.0 (376290515 2024-07-16)";
+        assert_eq!(parse_version(out), Some((1, 80, 0)));
+        assert_eq!(parse_version("0.14.0-dev.1+abc"), Some((0, 14, 0)));
+        assert_eq!(parse_version("wasm-opt version 116"), Some((116, 0, 0)));
+        assert_eq!(parse_version("no version here"), None);
+    }
+
+    #[test]
+    fn test_has_target() {
+        assert!(has_target(
+            "x86_64-unknown-linux-gnu\nwasm32-unknown-unknown\n"
+        ));
+        assert!(!has_target("x86_64-unknown-linux-gnu\nwasm32-wasip1\n"));
+        assert!(!has_target(""));
+    }
+
+    #[test]
+    fn test_check_tool() {
+        let tool = Tool {
+            name:        "surely-not-installed-binary",
+            args:        &["version"],
+            min_version: (1, 0, 0),
+            required:    true,
+            install_url: "",
+        };
+        let check = check_tool(&tool);
+        assert_eq!(check.version, None);
+        assert_eq!(check.problem.as_deref(), Some("not found in PATH"));
+    }
+}
@@ -0,0 +1,120 @@
+use crate::budget::format_size;
+use crate::file_names::BIN;
+use crate::output::{is_json, print_json};
+use crate::vfs::{dir_size, list_apps};
+use anyhow::Result;
+use colored::Colorize;
+use serde::Serialize;
+use std::path::Path;
+use std::process::Command;
+
+/// Disk usage of a single app, in bytes.
+#[derive(Serialize)]
+struct AppUsage {
+    id:     String,
+    /// The size of the wasm binary.
+    code:   u64,
+    /// The size of all other ROM files.
+    assets: u64,
+    /// The size of the app data, including saves.
+    data:   u64,
+    total:  u64,
+}
+
+#[derive(Serialize)]
+struct Usage {
+    apps:  Vec<AppUsage>,
+    /// The size of the whole VFS, including system files.
+    total: u64,
+    /// Free space on the disk where VFS is located, if known.
+    free:  Option<u64>,
+}
+
+pub fn cmd_du(vfs: &Path) -> Result<()> {
+    let mut apps = Vec::new();
+    for (author_id, app_id) in list_apps(vfs)? {
+        let rom_path = vfs.join("roms").join(&author_id).join(&app_id);
+        let data_path = vfs.join("data").join(&author_id).join(&app_id);
+        let rom_size = dir_size(&rom_path);
+        let code = std::fs::metadata(rom_path.join(BIN)).map_or(0, |meta| meta.len());
+        let data = dir_size(&data_path);
+        apps.push(AppUsage {
+            id: format!("{author_id}.{app_id}"),
+            code,
+            assets: rom_size.saturating_sub(code),
+            data,
+            total: rom_size + data,
+        });
+    }
+    apps.sort_by(|a, b| b.total.cmp(&a.total).then(a.id.cmp(&b.id)));
+    let usage = Usage {
+        apps,
+        total: dir_size(vfs),
+        free: free_space(vfs),
+    };
+    if is_json() {
+        return print_json(&usage);
+    }
+    print_usage(&usage);
+    Ok(())
+}
+
+fn print_usage(usage: &Usage) {
+    println!(
+        "{:24} {:>10} {:>10} {:>10} {:>10}",
+        "ID".bold(),
+        "CODE".bold(),
+        "ASSETS".bold(),
+        "DATA".bold(),
+        "TOTAL".bold(),
+    );
+    for app in &usage.apps {
+        println!(
+            "{:24} {:>10} {:>10} {:>10} {:>10}",
+            app.id,
+            format_size(app.code),
+            format_size(app.assets),
+            format_size(app.data),
+            format_size(app.total),
+        );
+    }
+    println!();
+    println!("{} {}", "VFS total:".cyan(), format_size(usage.total));
+    if let Some(free) = usage.free {
+        println!("{} {}", "free:     ".cyan(), format_size(free));
+    }
+}
+
+/// Get free space on the disk using `df`.
+///
+/// There is no portable way to do it in the standard library.
+/// If `df` is not available (for example, on Windows), returns None.
+fn free_space(path: &Path) -> Option<u64> {
+    let output = Command::new("df").arg("-Pk").arg(path).output().ok()?;
+    if !output.status.success() {
+        return None;
+    }
+    let stdout = String::from_utf8(output.stdout).ok()?;
+    parse_df(&stdout)
+}
+
+/// Parse the "Available" column (in Kb) of the POSIX `df -P` output.
+fn parse_df(stdout: &str) -> Option<u64> {
+    let line = stdout.lines().nth(1)?;
+    let available: u64 = line.split_whitespace().nth(3)?.parse().ok()?;
+    Some(available * 1024)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_df() {
+        let stdout = "Filesystem     1024-blocks     Used Available Capacity Mounted on\n\
+                      /dev/nvme0n1p2   490617784 71837204 393779860      16% /\n";
+        assert_eq!(parse_df(stdout), Some(393_779_860 * 1024));
+        assert_eq!(parse_df(""), None);
+        assert_eq!(parse_df("header\nbroken line\n"), None);
+    }
+}
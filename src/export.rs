@@ -1,20 +1,52 @@
 use crate::args::ExportArgs;
 use crate::config::Config;
-use anyhow::{Context, Result};
-use std::fs::{read_dir, File};
+use crate::output::{is_json, print_json};
+use anyhow::{bail, Context, Result};
+use serde::Serialize;
+use std::fs::{create_dir_all, read_dir, File};
 use std::io::{Read, Write};
 use std::path::{Path, PathBuf};
 use zip::write::FileOptions;
-use zip::{CompressionMethod, ZipWriter};
+use zip::{CompressionMethod, DateTime, ZipWriter};
+
+/// The compression level for zstd. Fixed so that the archive is reproducible.
+const COMPRESSION_LEVEL: i64 = 3;
+
+/// The result of the export, for the JSON output.
+#[derive(Serialize)]
+struct ExportInfo<'a> {
+    id:   String,
+    path: &'a str,
+    size: u64,
+}
 
 pub fn cmd_export(vfs: &Path, args: &ExportArgs) -> Result<()> {
     let (author_id, app_id) = get_id(vfs.to_path_buf(), args)?;
     let rom_path = vfs.join("roms").join(&author_id).join(&app_id);
+    let file_name = format!("{author_id}.{app_id}.zip");
     let out_path: PathBuf = match &args.output {
-        Some(out_path) => out_path.clone(),
-        None => format!("{author_id}.{app_id}.zip").into(),
+        Some(out_path) => resolve_output(out_path, &file_name),
+        None => file_name.into(),
     };
-    archive(&rom_path, &out_path).context("create archive")?;
+    // The default output in the current directory is always overwritten,
+    // only an explicitly passed target is protected.
+    if args.output.is_some() && out_path.exists() && !args.force {
+        let out_path = out_path.display();
+        bail!("the file {out_path} already exists, use --force to overwrite it");
+    }
+    if let Some(parent) = out_path.parent() {
+        if !parent.as_os_str().is_empty() {
+            create_dir_all(parent).context("create output directory")?;
+        }
+    }
+    let mtime = source_date(args.source_date)?;
+    archive(&rom_path, &out_path, mtime).context("create archive")?;
+    if is_json() {
+        let size = std::fs::metadata(&out_path).map_or(0, |meta| meta.len());
+        let path = out_path.to_str().context("convert output path to UTF-8")?;
+        let id = format!("{author_id}.{app_id}");
+        return print_json(&ExportInfo { id, path, size });
+    }
     let out_path = out_path.as_os_str();
     if let Some(out_path) = out_path.to_str() {
         println!("✅ exported: {out_path}");
@@ -22,29 +54,93 @@ pub fn cmd_export(vfs: &Path, args: &ExportArgs) -> Result<()> {
     Ok(())
 }
 
+/// If the output path is a directory, put the archive with the default name into it.
+fn resolve_output(out_path: &Path, file_name: &str) -> PathBuf {
+    let is_dir = match out_path.to_str() {
+        Some(path) => path.ends_with('/') || path.ends_with(std::path::MAIN_SEPARATOR),
+        None => false,
+    };
+    if is_dir || out_path.is_dir() {
+        return out_path.join(file_name);
+    }
+    out_path.to_path_buf()
+}
+
 fn get_id(vfs: PathBuf, args: &ExportArgs) -> Result<(String, String)> {
     let res = if let (Some(author), Some(app)) = (&args.author, &args.app) {
         (author.to_string(), app.to_string())
     } else {
-        let config = Config::load(vfs, &args.root).context("read project config")?;
+        let config =
+            Config::load(vfs, &args.root, args.allow_unknown).context("read project config")?;
         (config.author_id, config.app_id)
     };
     Ok(res)
 }
 
-fn archive(in_path: &Path, out_path: &Path) -> Result<()> {
+/// Get the modification time for all archived files.
+///
+/// The same time is used for all files and it doesn't depend on when
+/// they were built, so that exporting the same ROM twice produces the same archive.
+fn source_date(source_date: Option<i64>) -> Result<DateTime> {
+    let timestamp = match source_date {
+        Some(timestamp) => timestamp,
+        None => match std::env::var("SOURCE_DATE_EPOCH") {
+            Ok(raw) => raw.parse().context("parse SOURCE_DATE_EPOCH")?,
+            Err(_) => return Ok(DateTime::default()),
+        },
+    };
+    zip_date(timestamp)
+}
+
+/// Convert unix timestamp into the date format used by zip.
+fn zip_date(timestamp: i64) -> Result<DateTime> {
+    let days = timestamp.div_euclid(86_400);
+    let secs = timestamp.rem_euclid(86_400);
+    // The algorithm is from http://howardhinnant.github.io/date_algorithms.html#civil_from_days
+    let days = days + 719_468;
+    let era = days.div_euclid(146_097);
+    let day_of_era = days.rem_euclid(146_097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1460 + day_of_era / 36_524 - day_of_era / 146_096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let mp = (5 * day_of_year + 2) / 153;
+    let day = day_of_year - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = year_of_era + era * 400 + i64::from(month <= 2);
+
+    let (Ok(year), Ok(month), Ok(day)) = (year.try_into(), month.try_into(), day.try_into()) else {
+        bail!("the source date is out of range");
+    };
+    // All values are less than 60, the conversion cannot fail.
+    let hour = u8::try_from(secs / 3600).unwrap_or_default();
+    let minute = u8::try_from(secs / 60 % 60).unwrap_or_default();
+    let second = u8::try_from(secs % 60).unwrap_or_default();
+    let Ok(date) = DateTime::from_date_and_time(year, month, day, hour, minute, second) else {
+        bail!("the source date must be between 1980 and 2107");
+    };
+    Ok(date)
+}
+
+fn archive(in_path: &Path, out_path: &Path, mtime: DateTime) -> Result<()> {
     // Should go first so that we don't create empty archive
     // if ROM doesn't exist.
     let entries = read_dir(in_path).context("read ROM dir")?;
+    let mut entries = entries
+        .collect::<Result<Vec<_>, _>>()
+        .context("get dir entry")?;
+    // Files must always go in the same order, no matter in which order
+    // they were written into the ROM dir or how the file system lists them.
+    entries.sort_by_key(std::fs::DirEntry::file_name);
 
     let out_file = File::create(out_path).context("create archive file")?;
     let mut zip = ZipWriter::new(out_file);
     let options = FileOptions::<()>::default()
         .compression_method(CompressionMethod::Zstd)
+        .compression_level(Some(COMPRESSION_LEVEL))
+        .last_modified_time(mtime)
         .unix_permissions(0o755);
 
     for entry in entries {
-        let entry = entry.context("get dir entry")?;
         let file_path = entry.file_name();
         let file_path = file_path.to_str().unwrap();
         let file_path = file_path.to_string();
@@ -58,3 +154,86 @@ fn archive(in_path: &Path, out_path: &Path) -> Result<()> {
     }
     Ok(())
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+    use std::fs;
+
+    #[test]
+    fn test_resolve_output() {
+        let name = "sys.launcher.zip";
+        let path = resolve_output(Path::new("dist/game.zip"), name);
+        assert_eq!(path, PathBuf::from("dist/game.zip"));
+        let path = resolve_output(Path::new("dist/"), name);
+        assert_eq!(path, PathBuf::from("dist/sys.launcher.zip"));
+
+        let dir = make_tmp_dir();
+        let path = resolve_output(&dir, name);
+        assert_eq!(path, dir.join(name));
+    }
+
+    #[test]
+    fn test_zip_date() {
+        let expected = DateTime::from_date_and_time(2024, 2, 29, 13, 14, 15).unwrap();
+        assert_eq!(zip_date(1_709_212_455).unwrap(), expected);
+        let expected = DateTime::from_date_and_time(1980, 1, 1, 0, 0, 0).unwrap();
+        assert_eq!(zip_date(315_532_800).unwrap(), expected);
+        assert!(zip_date(0).is_err());
+    }
+
+    #[test]
+    fn test_export_reproducible() {
+        use crate::args::BuildArgs;
+        use crate::build::build;
+        use crate::file_names::HASH;
+        use clap::Parser;
+        use std::time::{Duration, SystemTime};
+
+        let vfs = make_tmp_vfs();
+        let root = make_tmp_dir();
+        let config = r#"
+author_id = "greg"
+app_id = "snek"
+author_name = "Greg"
+app_name = "Snek"
+
+[files]
+_bin = { path = "main.wasm", copy = true }
+font = { path = "font.fff" }
+"#;
+        fs::write(root.join("firefly.toml"), config).unwrap();
+        fs::write(root.join("main.wasm"), b"\0asm\x01\0\0\0").unwrap();
+        fs::write(root.join("font.fff"), "font").unwrap();
+        let rom_path = vfs.join("roms").join("greg").join("snek");
+        let build_args = BuildArgs::parse_from(["build", "--no-tip", root.to_str().unwrap()]);
+
+        let mut hashes = Vec::new();
+        let mut archives = Vec::new();
+        for (name, mtime) in [("a.zip", 1_000_000_000), ("b.zip", 1_500_000_000)] {
+            build(vfs.clone(), &build_args).unwrap();
+            // The modification time of the ROM files must not affect the archive.
+            let mtime = SystemTime::UNIX_EPOCH + Duration::from_secs(mtime);
+            for entry in fs::read_dir(&rom_path).unwrap() {
+                let file = File::options().write(true).open(entry.unwrap().path());
+                file.unwrap().set_modified(mtime).unwrap();
+            }
+            hashes.push(fs::read(rom_path.join(HASH)).unwrap());
+            let out_path = root.join(name);
+            let export_args = ExportArgs {
+                root:          root.clone(),
+                author:        None,
+                app:           None,
+                output:        Some(out_path.clone()),
+                force:         false,
+                allow_unknown: false,
+                source_date:   Some(1_700_000_000),
+            };
+            cmd_export(&vfs, &export_args).unwrap();
+            archives.push(fs::read(out_path).unwrap());
+        }
+        assert_eq!(hashes[0], hashes[1]);
+        assert_eq!(archives[0], archives[1]);
+    }
+}
@@ -12,3 +12,12 @@ pub const META: &str = "_meta";
 
 /// The public key that can verify the author's signature.
 pub const KEY: &str = "_key";
+
+/// The list of badges (achievements) that the app provides.
+pub const BADGES: &str = "_badges";
+
+/// The list of scoreboards (leaderboards) that the app provides.
+pub const BOARDS: &str = "_boards";
+
+/// Translations of the app name and description.
+pub const LOCALES: &str = "_locales";
@@ -1,11 +1,22 @@
+use crate::aseprite::{aseprite_size, decode_aseprite};
+use crate::config::{Dither, FileConfig, SliceConfig};
 use anyhow::{bail, Context, Result};
 use image::{Pixel, Rgb, Rgba, RgbaImage};
+use std::collections::BTreeMap;
+use std::fmt::Write as _;
 use std::fs::File;
 use std::io::Write;
 use std::path::Path;
 
 type Color = Option<Rgb<u8>>;
 
+/// The device screen size, the default limit for image dimensions.
+const SCREEN_WIDTH: u32 = 240;
+const SCREEN_HEIGHT: u32 = 160;
+
+/// How many unknown colors to list in the error message.
+const MAX_REPORTED_COLORS: usize = 10;
+
 static DEFAULT_PALETTE: &[Option<Rgb<u8>>] = &[
     // https://lospec.com/palette-list/sweetie-16
     // https://github.com/nesbox/TIC-80/wiki/Palette
@@ -27,31 +38,255 @@ static DEFAULT_PALETTE: &[Option<Rgb<u8>>] = &[
     Some(Rgb([0x33, 0x3c, 0x57])), // dark gray
 ];
 
-pub fn convert_image(input_path: &Path, output_path: &Path) -> Result<()> {
-    let file = image::io::Reader::open(input_path).context("open image file")?;
-    let img = file.decode().context("decode image")?;
-    let img = img.to_rgba8();
+pub fn convert_image(
+    input_path: &Path,
+    output_path: &Path,
+    file_config: &FileConfig,
+) -> Result<()> {
+    let mut img = load_image(input_path, file_config)?;
+    if let Some(slice) = &file_config.slice {
+        img = slice_image(&img, slice).context("slice image")?;
+    }
+    let source = input_path.display().to_string();
+    encode_image(img, &source, output_path, file_config)
+}
+
+/// Write the already loaded image in the firefly format.
+///
+/// The source is a human-readable name of the image used in error messages.
+pub fn encode_image(
+    mut img: RgbaImage,
+    source: &str,
+    output_path: &Path,
+    file_config: &FileConfig,
+) -> Result<()> {
+    if let Some(hex) = &file_config.transparent_color {
+        let key = parse_color_key(hex, &file_config.remap).context("parse transparent_color")?;
+        apply_color_key(&mut img, key);
+    }
+    if !file_config.remap.is_empty() {
+        let remap = parse_remap(&file_config.remap).context("parse remap")?;
+        remap_colors(&mut img, &remap);
+    }
+    if matches!(file_config.dither, Dither::FloydSteinberg) {
+        dither_floyd_steinberg(&mut img);
+    }
     if img.width() % 8 != 0 {
         bail!("image width must be divisible by 8");
     }
-    let palette = make_palette(&img).context("detect colors used in the image")?;
+    let palette =
+        make_palette(&img).with_context(|| format!("detect colors used in the image {source}"))?;
+    let bpp = pick_bpp(palette.len(), file_config.bpp)?;
     let mut out = File::create(output_path).context("create output path")?;
     write_u8(&mut out, 0x21)?;
-    let colors = palette.len();
-    if colors <= 2 {
-        let palette = extend_palette(palette, 2);
-        write_image::<1, 8>(out, &img, &palette).context("write 1BPP image")
-    } else if colors <= 4 {
-        let palette = extend_palette(palette, 4);
-        write_image::<2, 4>(out, &img, &palette).context("write 1BPP image")
-    } else if colors <= 16 {
-        let palette = extend_palette(palette, 16);
-        write_image::<4, 2>(out, &img, &palette).context("write 1BPP image")
-    } else {
-        bail!("the image has too many colors")
+    match bpp {
+        1 => {
+            let palette = extend_palette(palette, 2);
+            write_image::<1, 8>(out, &img, &palette).context("write 1BPP image")
+        }
+        2 => {
+            let palette = extend_palette(palette, 4);
+            write_image::<2, 4>(out, &img, &palette).context("write 2BPP image")
+        }
+        _ => {
+            let palette = extend_palette(palette, 16);
+            write_image::<4, 2>(out, &img, &palette).context("write 4BPP image")
+        }
     }
 }
 
+/// Choose how many bits per pixel to use for the image with the given number of colors.
+///
+/// If BPP is not specified in the config, the smallest one that fits all colors is used.
+fn pick_bpp(colors: usize, requested: Option<u8>) -> Result<u8> {
+    let Some(bpp) = requested else {
+        return match colors {
+            0..=2 => Ok(1),
+            3..=4 => Ok(2),
+            5..=16 => Ok(4),
+            _ => {
+                bail!("the image uses all 16 colors and transparency, there is no slot left for it")
+            }
+        };
+    };
+    if !matches!(bpp, 1 | 2 | 4) {
+        bail!("unsupported bpp value {bpp}, must be 1, 2, or 4");
+    }
+    let max_colors = 1 << bpp;
+    if colors > max_colors {
+        bail!("the image uses {colors} colors (including transparency) but {bpp} BPP allows only {max_colors}");
+    }
+    Ok(bpp)
+}
+
+/// Check the size of the image (or a single tile) without converting it.
+///
+/// Reads only the image header, so it's cheap enough to run on every build,
+/// even when the converted image is restored from the cache.
+pub fn check_image_size(input_path: &Path, file_config: &FileConfig) -> Result<()> {
+    let (width, height) = match &file_config.slice {
+        Some(slice) => (slice.tile_width, slice.tile_height),
+        None => read_image_size(input_path, file_config)?,
+    };
+    let path = input_path.display();
+    if let Some(warning) = check_dimensions(width, height, file_config)
+        .with_context(|| format!("check size of the image {path}"))?
+    {
+        eprintln!("⚠️  {path}: {warning}");
+    }
+    Ok(())
+}
+
+fn read_image_size(input_path: &Path, file_config: &FileConfig) -> Result<(u32, u32)> {
+    let extension = input_path.extension().and_then(|ext| ext.to_str());
+    if let Some("ase" | "aseprite") = extension {
+        let raw = std::fs::read(input_path).context("read Aseprite file")?;
+        return aseprite_size(&raw, &file_config.frames).context("decode Aseprite file");
+    }
+    image::image_dimensions(input_path).context("read image size")
+}
+
+/// Check that the image (or a single tile) is not too big and has a typical size.
+///
+/// Returns an error if the image is bigger than allowed
+/// and a warning message if the size is unusual.
+fn check_dimensions(width: u32, height: u32, file_config: &FileConfig) -> Result<Option<String>> {
+    let max_width = file_config.max_width.unwrap_or(SCREEN_WIDTH);
+    let max_height = file_config.max_height.unwrap_or(SCREEN_HEIGHT);
+    if width > max_width || height > max_height {
+        bail!(
+            "the size {width}x{height} is bigger than the maximum {max_width}x{max_height}, \
+            set max_width and max_height if it's intentional"
+        );
+    }
+    if height % 8 != 0 && !file_config.allow_odd_size {
+        let msg = format!(
+            "the height {height} is not a multiple of 8, \
+            set allow_odd_size = true if it's intentional"
+        );
+        return Ok(Some(msg));
+    }
+    Ok(None)
+}
+
+/// Read and decode the image from a PNG or Aseprite file.
+pub fn load_image(input_path: &Path, file_config: &FileConfig) -> Result<RgbaImage> {
+    let extension = input_path.extension().and_then(|ext| ext.to_str());
+    if let Some("ase" | "aseprite") = extension {
+        let raw = std::fs::read(input_path).context("read Aseprite file")?;
+        let img = decode_aseprite(&raw, &file_config.frames).context("decode Aseprite file")?;
+        return Ok(img);
+    }
+    let file = image::io::Reader::open(input_path).context("open image file")?;
+    let img = file.decode().context("decode image")?;
+    Ok(img.to_rgba8())
+}
+
+/// Parse the mapping of hex colors to the default palette indices.
+fn parse_remap(remap: &BTreeMap<String, u8>) -> Result<Vec<(Rgb<u8>, Rgb<u8>)>> {
+    let mut result = Vec::new();
+    for (hex, index) in remap {
+        let Some(source) = parse_hex_color(hex) else {
+            bail!("invalid color {hex:?}, must be in the #RRGGBB format");
+        };
+        let Some(Some(target)) = DEFAULT_PALETTE.get(usize::from(*index)) else {
+            bail!("invalid palette index {index} for {hex}, must be from 0 to 15");
+        };
+        result.push((source, *target));
+    }
+    Ok(result)
+}
+
+/// Parse color in the "#RRGGBB" (or "RRGGBB") format.
+fn parse_hex_color(hex: &str) -> Option<Rgb<u8>> {
+    let hex = hex.strip_prefix('#').unwrap_or(hex);
+    if hex.len() != 6 || !hex.is_ascii() {
+        return None;
+    }
+    let r = u8::from_str_radix(&hex[0..2], 16).ok()?;
+    let g = u8::from_str_radix(&hex[2..4], 16).ok()?;
+    let b = u8::from_str_radix(&hex[4..6], 16).ok()?;
+    Some(Rgb([r, g, b]))
+}
+
+/// Replace every opaque pixel of a remapped color by the target palette color.
+fn remap_colors(img: &mut RgbaImage, remap: &[(Rgb<u8>, Rgb<u8>)]) {
+    for pixel in img.pixels_mut() {
+        if is_transparent(*pixel) {
+            continue;
+        }
+        let color = pixel.to_rgb();
+        if let Some((_, target)) = remap.iter().find(|(source, _)| *source == color) {
+            pixel.0[..3].copy_from_slice(&target.0);
+        }
+    }
+}
+
+/// Parse the color that should be treated as transparent.
+///
+/// The color cannot be one of the palette colors or remapped to one
+/// because then it's not clear if the pixels of that color should be transparent or not.
+fn parse_color_key(hex: &str, remap: &BTreeMap<String, u8>) -> Result<Rgb<u8>> {
+    let Some(key) = parse_hex_color(hex) else {
+        bail!("invalid color {hex:?}, must be in the #RRGGBB format");
+    };
+    if DEFAULT_PALETTE.contains(&Some(key)) {
+        bail!("the color {hex} is in the palette, it cannot be transparent");
+    }
+    for source in remap.keys() {
+        if parse_hex_color(source) == Some(key) {
+            bail!("the color {hex} is remapped to a palette color, it cannot be transparent");
+        }
+    }
+    Ok(key)
+}
+
+/// Make every pixel of the given color fully transparent.
+fn apply_color_key(img: &mut RgbaImage, key: Rgb<u8>) {
+    for pixel in img.pixels_mut() {
+        if pixel.to_rgb() == key {
+            pixel.0[3] = 0;
+        }
+    }
+}
+
+/// Cut the spritesheet into tiles and put them side by side in a single row.
+///
+/// The tiles are taken left to right, top to bottom.
+fn slice_image(img: &RgbaImage, slice: &SliceConfig) -> Result<RgbaImage> {
+    let (width, height) = img.dimensions();
+    let (tile_width, tile_height) = (slice.tile_width, slice.tile_height);
+    if tile_width == 0 || tile_height == 0 {
+        bail!("tile size must not be zero");
+    }
+    if width % tile_width != 0 || height % tile_height != 0 {
+        bail!(
+            "the image size {width}x{height} is not a multiple of the tile size {tile_width}x{tile_height}"
+        );
+    }
+    let columns = width / tile_width;
+    let available = columns * (height / tile_height);
+    let count = slice.count.unwrap_or(available);
+    if count == 0 {
+        bail!("tiles count must not be zero");
+    }
+    if count > available {
+        bail!("requested {count} tiles but the image has only {available}");
+    }
+    let mut out = RgbaImage::new(tile_width * count, tile_height);
+    for tile in 0..count {
+        let src_x = (tile % columns) * tile_width;
+        let src_y = (tile / columns) * tile_height;
+        for y in 0..tile_height {
+            for x in 0..tile_width {
+                let pixel = *img.get_pixel(src_x + x, src_y + y);
+                out.put_pixel(tile * tile_width + x, y, pixel);
+            }
+        }
+    }
+    Ok(out)
+}
+
 fn write_image<const BPP: u8, const PPB: usize>(
     mut out: File,
     img: &RgbaImage,
@@ -93,19 +328,28 @@ fn write_image<const BPP: u8, const PPB: usize>(
 }
 
 /// Detect all colors used in the image
+///
+/// If some colors are not in the default palette, all of them are reported
+/// (up to [`MAX_REPORTED_COLORS`]) together with the first pixel where they occur.
+/// Transparent pixels always go into the reserved transparent slot.
 fn make_palette(img: &RgbaImage) -> Result<Vec<Color>> {
     let mut palette = Vec::new();
-    for pixel in img.pixels() {
+    let mut unknown: Vec<(Rgba<u8>, u32, u32)> = Vec::new();
+    for (x, y, pixel) in img.enumerate_pixels() {
         let color = convert_color(*pixel);
-        if !palette.contains(&color) {
-            if color.is_some() && !DEFAULT_PALETTE.contains(&color) {
-                bail!(
-                    "found a color not present in the default color palette: {}",
-                    format_color(color)
-                );
+        if palette.contains(&color) {
+            continue;
+        }
+        if color.is_some() && !DEFAULT_PALETTE.contains(&color) {
+            if !unknown.iter().any(|(c, _, _)| c.to_rgb() == pixel.to_rgb()) {
+                unknown.push((*pixel, x, y));
             }
-            palette.push(color);
+            continue;
         }
+        palette.push(color);
+    }
+    if !unknown.is_empty() {
+        bail!(format_unknown_colors(&unknown));
     }
     palette.sort_by_key(|c| match c {
         Some(c) => find_color_default(*c),
@@ -114,6 +358,92 @@ fn make_palette(img: &RgbaImage) -> Result<Vec<Color>> {
     Ok(palette)
 }
 
+/// Make human-friendly list of colors not present in the default palette.
+fn format_unknown_colors(unknown: &[(Rgba<u8>, u32, u32)]) -> String {
+    let mut msg = format!(
+        "found {} color(s) not present in the default color palette:",
+        unknown.len()
+    );
+    for (color, x, y) in unknown.iter().take(MAX_REPORTED_COLORS) {
+        let color = format_rgba(*color);
+        _ = write!(msg, "\n  {color} first used at x={x}, y={y}");
+    }
+    if unknown.len() > MAX_REPORTED_COLORS {
+        let rest = unknown.len() - MAX_REPORTED_COLORS;
+        _ = write!(msg, "\n  ...and {rest} more");
+    }
+    msg
+}
+
+/// Replace every opaque color by the nearest palette color using Floyd–Steinberg dithering.
+///
+/// The quantization error of each pixel is spread over its neighbors that aren't processed yet.
+/// Transparent pixels are left as is and never receive the error.
+///
+/// <https://en.wikipedia.org/wiki/Floyd%E2%80%93Steinberg_dithering>
+fn dither_floyd_steinberg(img: &mut RgbaImage) {
+    let (width, height) = img.dimensions();
+    let index = |x: u32, y: u32| (y * width + x) as usize;
+    let mut errors = vec![[0i32; 3]; index(0, height)];
+    for y in 0..height {
+        for x in 0..width {
+            let pixel = img.get_pixel_mut(x, y);
+            if is_transparent(*pixel) {
+                continue;
+            }
+            let error = errors[index(x, y)];
+            let mut old = [0i32; 3];
+            for (i, channel) in old.iter_mut().enumerate() {
+                *channel = (i32::from(pixel.0[i]) + error[i]).clamp(0, 255);
+            }
+            let new = find_nearest_color(old);
+            pixel.0[..3].copy_from_slice(&new.0);
+            let diff = [
+                old[0] - i32::from(new.0[0]),
+                old[1] - i32::from(new.0[1]),
+                old[2] - i32::from(new.0[2]),
+            ];
+
+            let neighbors: [(i32, u32, i32); 4] = [(1, 0, 7), (-1, 1, 3), (0, 1, 5), (1, 1, 1)];
+            for (dx, dy, weight) in neighbors {
+                let Some(nx) = x.checked_add_signed(dx) else {
+                    continue;
+                };
+                let ny = y + dy;
+                if nx >= width || ny >= height {
+                    continue;
+                }
+                if is_transparent(*img.get_pixel(nx, ny)) {
+                    continue;
+                }
+                let target = &mut errors[index(nx, ny)];
+                for (channel, diff) in target.iter_mut().zip(diff) {
+                    *channel += diff * weight / 16;
+                }
+            }
+        }
+    }
+}
+
+/// Find the default palette color closest to the given one.
+fn find_nearest_color(c: [i32; 3]) -> Rgb<u8> {
+    let mut best = Rgb([0, 0, 0]);
+    let mut best_distance = i32::MAX;
+    for color in DEFAULT_PALETTE.iter().flatten() {
+        let distance: i32 = color
+            .0
+            .iter()
+            .zip(c)
+            .map(|(a, b)| (i32::from(*a) - b).pow(2))
+            .sum();
+        if distance < best_distance {
+            best = *color;
+            best_distance = distance;
+        }
+    }
+    best
+}
+
 /// Add empty colors at the end of the palette to match the BPP size.
 fn extend_palette(mut palette: Vec<Color>, size: usize) -> Vec<Color> {
     let n = size - palette.len();
@@ -146,15 +476,10 @@ fn find_color(palette: &[Color], c: Color) -> u8 {
     panic!("color not in the default palette")
 }
 
-/// Make human-friendly hex representation of the color code.
-fn format_color(c: Color) -> String {
-    match c {
-        Some(c) => {
-            let c = c.0;
-            format!("#{:02X}{:02X}{:02X}", c[0], c[1], c[2])
-        }
-        None => "ALPHA".to_string(),
-    }
+/// Make human-friendly hex representation of the color code, including alpha.
+fn format_rgba(c: Rgba<u8>) -> String {
+    let c = c.0;
+    format!("#{:02X}{:02X}{:02X}{:02X}", c[0], c[1], c[2], c[3])
 }
 
 fn convert_color(c: Rgba<u8>) -> Color {
@@ -194,9 +519,196 @@ mod tests {
     use super::*;
 
     #[test]
-    fn test_format_color() {
-        assert_eq!(format_color(None), "ALPHA");
-        assert_eq!(format_color(Some(Rgb([0x89, 0xab, 0xcd]))), "#89ABCD");
+    fn test_format_rgba() {
+        assert_eq!(format_rgba(Rgba([0x89, 0xab, 0xcd, 0xef])), "#89ABCDEF");
+        assert_eq!(format_rgba(Rgba([0x01, 0x02, 0x03, 0xff])), "#010203FF");
+    }
+
+    #[test]
+    fn test_make_palette_unknown_colors() {
+        let black = Rgba([0x1a, 0x1c, 0x2c, 0xff]);
+        let mut img = RgbaImage::from_pixel(8, 2, black);
+        img.put_pixel(3, 1, Rgba([0xff, 0x00, 0x00, 0xff]));
+        img.put_pixel(5, 1, Rgba([0xff, 0x00, 0x00, 0xff]));
+        img.put_pixel(6, 0, Rgba([0x00, 0xff, 0x00, 0xff]));
+        img.put_pixel(7, 0, Rgba([0x00, 0xff, 0x00, 0x00]));
+        let err = make_palette(&img).unwrap_err().to_string();
+        assert!(err.contains("found 2 color(s)"), "{err}");
+        assert!(err.contains("#FF0000FF first used at x=3, y=1"), "{err}");
+        assert!(err.contains("#00FF00FF first used at x=6, y=0"), "{err}");
+    }
+
+    #[test]
+    fn test_make_palette_transparent() {
+        let black = Rgba([0x1a, 0x1c, 0x2c, 0xff]);
+        let mut img = RgbaImage::from_pixel(8, 1, black);
+        img.put_pixel(2, 0, Rgba([0xff, 0x00, 0xff, 0x00]));
+        let palette = make_palette(&img).unwrap();
+        assert_eq!(palette, vec![DEFAULT_PALETTE[0], None]);
+    }
+
+    #[test]
+    fn test_slice_image() {
+        // 2x2 grid of 2x1 tiles, each tile filled with its own color
+        let colors = [
+            Rgba([1, 0, 0, 255]),
+            Rgba([2, 0, 0, 255]),
+            Rgba([3, 0, 0, 255]),
+            Rgba([4, 0, 0, 255]),
+        ];
+        let img = RgbaImage::from_fn(4, 2, |x, y| colors[(y * 2 + x / 2) as usize]);
+        let slice = SliceConfig {
+            tile_width:  2,
+            tile_height: 1,
+            count:       None,
+        };
+        let out = slice_image(&img, &slice).unwrap();
+        assert_eq!(out.dimensions(), (8, 1));
+        for (i, color) in colors.iter().enumerate() {
+            let x = u32::try_from(i).unwrap() * 2;
+            assert_eq!(out.get_pixel(x, 0), color);
+            assert_eq!(out.get_pixel(x + 1, 0), color);
+        }
+
+        let slice = SliceConfig {
+            tile_width:  2,
+            tile_height: 1,
+            count:       Some(3),
+        };
+        let out = slice_image(&img, &slice).unwrap();
+        assert_eq!(out.dimensions(), (6, 1));
+
+        let slice = SliceConfig {
+            tile_width:  3,
+            tile_height: 1,
+            count:       None,
+        };
+        let err = slice_image(&img, &slice).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "the image size 4x2 is not a multiple of the tile size 3x1"
+        );
+
+        let slice = SliceConfig {
+            tile_width:  2,
+            tile_height: 1,
+            count:       Some(5),
+        };
+        assert!(slice_image(&img, &slice).is_err());
+    }
+
+    #[test]
+    fn test_check_dimensions() {
+        let mut file_config = FileConfig::default();
+        assert_eq!(check_dimensions(240, 160, &file_config).unwrap(), None);
+        assert_eq!(check_dimensions(8, 8, &file_config).unwrap(), None);
+        let err = check_dimensions(248, 160, &file_config).unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("the size 248x160 is bigger than the maximum 240x160"));
+        assert!(check_dimensions(8, 161, &file_config).is_err());
+        assert!(check_dimensions(8, 12, &file_config).unwrap().is_some());
+
+        file_config.max_width = Some(1024);
+        file_config.allow_odd_size = true;
+        assert_eq!(check_dimensions(1024, 12, &file_config).unwrap(), None);
+    }
+
+    #[test]
+    fn test_pick_bpp() {
+        assert_eq!(pick_bpp(1, None).unwrap(), 1);
+        assert_eq!(pick_bpp(2, None).unwrap(), 1);
+        assert_eq!(pick_bpp(3, None).unwrap(), 2);
+        assert_eq!(pick_bpp(16, None).unwrap(), 4);
+        assert!(pick_bpp(17, None).is_err());
+        assert_eq!(pick_bpp(2, Some(4)).unwrap(), 4);
+        assert_eq!(pick_bpp(4, Some(2)).unwrap(), 2);
+        assert!(pick_bpp(3, Some(1)).is_err());
+        assert!(pick_bpp(2, Some(8)).is_err());
+    }
+
+    #[test]
+    fn test_parse_hex_color() {
+        assert_eq!(parse_hex_color("#FF0010"), Some(Rgb([0xff, 0x00, 0x10])));
+        assert_eq!(parse_hex_color("ff0010"), Some(Rgb([0xff, 0x00, 0x10])));
+        assert_eq!(parse_hex_color("#FF00"), None);
+        assert_eq!(parse_hex_color("#GG0000"), None);
+    }
+
+    #[test]
+    fn test_remap_colors() {
+        let mut remap = BTreeMap::new();
+        remap.insert("#FF0000".to_string(), 2);
+        let parsed = parse_remap(&remap).unwrap();
+        let mut img = RgbaImage::from_fn(2, 1, |x, _| {
+            if x == 0 {
+                Rgba([0xff, 0, 0, 255])
+            } else {
+                Rgba([0xff, 0, 0, 0])
+            }
+        });
+        remap_colors(&mut img, &parsed);
+        assert_eq!(img.get_pixel(0, 0), &Rgba([0xb1, 0x3e, 0x53, 255]));
+        // transparent pixels are not touched
+        assert_eq!(img.get_pixel(1, 0), &Rgba([0xff, 0, 0, 0]));
+
+        remap.insert("#00FF00".to_string(), 16);
+        let err = parse_remap(&remap).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "invalid palette index 16 for #00FF00, must be from 0 to 15"
+        );
+    }
+
+    #[test]
+    fn test_color_key() {
+        let mut remap = BTreeMap::new();
+        let key = parse_color_key("#ff00ff", &remap).unwrap();
+        assert_eq!(key, Rgb([0xff, 0, 0xff]));
+        let mut img = RgbaImage::from_fn(3, 1, |x, _| match x {
+            0 => Rgba([0xff, 0, 0xff, 255]),
+            1 => Rgba([0x1a, 0x1c, 0x2c, 255]),
+            _ => Rgba([0x1a, 0x1c, 0x2c, 0]),
+        });
+        apply_color_key(&mut img, key);
+        assert!(is_transparent(*img.get_pixel(0, 0)));
+        assert!(!is_transparent(*img.get_pixel(1, 0)));
+        assert!(is_transparent(*img.get_pixel(2, 0)));
+
+        assert!(parse_color_key("#1a1c2c", &remap).is_err());
+        assert!(parse_color_key("magenta", &remap).is_err());
+        remap.insert("#FF00FF".to_string(), 2);
+        let err = parse_color_key("#ff00ff", &remap).unwrap_err();
+        assert!(err.to_string().contains("is remapped"));
+    }
+
+    #[test]
+    fn test_find_nearest_color() {
+        assert_eq!(
+            find_nearest_color([0x1a, 0x1c, 0x2c]),
+            Rgb([0x1a, 0x1c, 0x2c])
+        );
+        assert_eq!(find_nearest_color([0, 0, 0]), Rgb([0x1a, 0x1c, 0x2c]));
+        assert_eq!(find_nearest_color([255, 255, 255]), Rgb([0xf4, 0xf4, 0xf4]));
+    }
+
+    #[test]
+    fn test_dither_floyd_steinberg() {
+        let mut img = RgbaImage::new(16, 4);
+        for (x, y, pixel) in img.enumerate_pixels_mut() {
+            let v = u8::try_from(x * 16).unwrap();
+            let a = if y == 2 && x == 5 { 0 } else { 255 };
+            *pixel = Rgba([v, v, v, a]);
+        }
+        dither_floyd_steinberg(&mut img);
+        for (x, y, pixel) in img.enumerate_pixels() {
+            if y == 2 && x == 5 {
+                assert_eq!(*pixel, Rgba([80, 80, 80, 0]), "transparent pixel changed");
+            } else {
+                assert!(DEFAULT_PALETTE.contains(&convert_color(*pixel)));
+            }
+        }
+        assert!(make_palette(&img).is_ok());
     }
 
     #[test]
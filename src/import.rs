@@ -1,19 +1,15 @@
 use crate::args::ImportArgs;
-use crate::crypto::hash_dir;
-use crate::file_names::{HASH, KEY, META, SIG};
+use crate::file_names::META;
+use crate::output::is_quiet;
+use crate::progress::{Progress, Unit};
+use crate::verify::verify_signature;
 use crate::vfs::init_vfs;
 use anyhow::{bail, Context, Result};
-use data_encoding::HEXLOWER;
 use firefly_meta::Meta;
-use rsa::pkcs1::DecodeRsaPublicKey;
-use rsa::pkcs1v15::{Signature, VerifyingKey};
-use rsa::signature::hazmat::PrehashVerifier;
-use rsa::RsaPublicKey;
 use serde::Deserialize;
-use sha2::Sha256;
 use std::env::temp_dir;
 use std::fs::{self, create_dir_all, File};
-use std::io::Read;
+use std::io::{Read, Write};
 use std::path::{Path, PathBuf};
 use zip::ZipArchive;
 
@@ -25,8 +21,52 @@ struct CatalogApp {
     download: String,
 }
 
+/// The ROM archive to be imported.
+enum Source {
+    /// The archive is a local file provided by the user.
+    Local(PathBuf),
+    /// The archive was downloaded into a temporary file.
+    Downloaded(TempFile),
+}
+
+impl Source {
+    fn path(&self) -> &Path {
+        match self {
+            Self::Local(path) => path,
+            Self::Downloaded(file) => &file.path,
+        }
+    }
+}
+
+/// A temporary file that is removed when dropped.
+struct TempFile {
+    path: PathBuf,
+}
+
+impl Drop for TempFile {
+    fn drop(&mut self) {
+        _ = fs::remove_file(&self.path);
+    }
+}
+
 pub fn cmd_import(vfs: &Path, args: &ImportArgs) -> Result<()> {
-    let path = fetch_archive(&args.path).context("download ROM archive")?;
+    let source = match (&args.from_url, &args.path) {
+        (Some(url), _) => {
+            if !url.starts_with("https://") && !url.starts_with("http://") {
+                bail!("the URL must start with https:// or http://");
+            }
+            let file = download_archive(url).context("download ROM archive")?;
+            Source::Downloaded(file)
+        }
+        (None, Some(path)) => fetch_archive(path).context("download ROM archive")?,
+        (None, None) => bail!("either the path or --from-url must be specified"),
+    };
+    // If the archive is downloaded, the temporary file is removed
+    // when `source` goes out of scope, no matter if the import succeeded.
+    import_archive(vfs, source.path())
+}
+
+fn import_archive(vfs: &Path, path: &Path) -> Result<()> {
     let file = File::open(path).context("open archive file")?;
     let mut archive = ZipArchive::new(file).context("open archive")?;
 
@@ -38,8 +78,8 @@ pub fn cmd_import(vfs: &Path, args: &ImportArgs) -> Result<()> {
     _ = fs::remove_dir_all(&rom_path);
     create_dir_all(&rom_path).context("create ROM dir")?;
     archive.extract(&rom_path).context("extract archive")?;
-    if let Err(err) = verify(&rom_path) {
-        println!("⚠️  verification failed: {err}");
+    if let Err(err) = verify_signature(&rom_path) {
+        eprintln!("⚠️  verification failed: {err}");
     }
     if let Some(rom_path) = rom_path.to_str() {
         println!("✅ installed: {rom_path}");
@@ -48,7 +88,7 @@ pub fn cmd_import(vfs: &Path, args: &ImportArgs) -> Result<()> {
     Ok(())
 }
 
-fn fetch_archive(path: &str) -> Result<PathBuf> {
+fn fetch_archive(path: &str) -> Result<Source> {
     let mut path = path.to_string();
     if path == "launcher" {
         path = "https://github.com/firefly-zero/firefly-launcher/releases/latest/download/sys.launcher.zip".to_string();
@@ -68,17 +108,54 @@ fn fetch_archive(path: &str) -> Result<PathBuf> {
 
     // Local path is given. Just use it.
     if !path.starts_with("https://") {
-        return Ok(path.into());
+        return Ok(Source::Local(path.into()));
     }
 
     // URL is given. Download into a temporary file.
-    println!("⏳️ downloading the file...");
-    let resp = ureq::get(&path).call().context("send HTTP request")?;
-    let out_path = temp_dir().join("rom.zip");
-    let mut file = File::create(&out_path)?;
-    std::io::copy(&mut resp.into_reader(), &mut file).context("write response into a file")?;
-    println!("⌛ installing...");
-    Ok(out_path)
+    let file = download_archive(&path)?;
+    Ok(Source::Downloaded(file))
+}
+
+/// Download the file from the given URL into a temporary file, showing the progress.
+fn download_archive(url: &str) -> Result<TempFile> {
+    if !is_quiet() {
+        eprintln!("⏳️ downloading the file...");
+    }
+    let resp = ureq::get(url).call().context("send HTTP request")?;
+    let expected_size: Option<u64> = resp
+        .header("Content-Length")
+        .and_then(|size| size.parse().ok());
+    let file_name = format!("firefly-rom-{}.zip", std::process::id());
+    // Create the guard before writing anything so that the file
+    // is cleaned up even if the download fails midway.
+    let temp_file = TempFile {
+        path: temp_dir().join(file_name),
+    };
+    let mut file = File::create(&temp_file.path).context("create temporary file")?;
+    let mut reader = resp.into_reader();
+    let mut buf = vec![0; 64 * 1024];
+    let mut size: u64 = 0;
+    let progress = Progress::new("downloading", Unit::Bytes, expected_size);
+    loop {
+        let n = reader.read(&mut buf).context("read response")?;
+        if n == 0 {
+            break;
+        }
+        file.write_all(&buf[..n])
+            .context("write response into a file")?;
+        size += n as u64;
+        progress.inc(n as u64);
+    }
+    progress.finish();
+    if let Some(expected_size) = expected_size {
+        if size != expected_size {
+            bail!("the download is truncated: got {size} out of {expected_size} bytes");
+        }
+    }
+    if !is_quiet() {
+        eprintln!("⌛ installing...");
+    }
+    Ok(temp_file)
 }
 
 fn read_meta_raw(archive: &mut ZipArchive<File>) -> Result<Vec<u8>> {
@@ -111,29 +188,3 @@ fn write_installed(meta: &Meta, vfs_path: &Path) -> anyhow::Result<()> {
     }
     Ok(())
 }
-
-/// Verify SHA256 hash, public key, and signature.
-fn verify(rom_path: &Path) -> anyhow::Result<()> {
-    let hash_path = rom_path.join(HASH);
-    let hash_expected: &[u8] = &fs::read(hash_path).context("read hash file")?;
-    let hash_actual: &[u8] = &hash_dir(rom_path).context("calculate hash")?;
-    if hash_actual != hash_expected {
-        let exp = HEXLOWER.encode(hash_expected);
-        let act = HEXLOWER.encode(hash_actual);
-        bail!("invalid hash:\n  expected: {exp}\n  got:      {act}");
-    }
-
-    let key_path = rom_path.join(KEY);
-    let key_raw = fs::read(key_path).context("read key from ROM")?;
-    let public_key = RsaPublicKey::from_pkcs1_der(&key_raw).context("decode key")?;
-    let verifying_key = VerifyingKey::<Sha256>::new(public_key);
-
-    let sig_path = rom_path.join(SIG);
-    let sig_raw: &[u8] = &fs::read(sig_path).context("read signature")?;
-    let sig = Signature::try_from(sig_raw).context("bad signature")?;
-
-    verifying_key
-        .verify_prehash(hash_actual, &sig)
-        .context("verify signature")?;
-    Ok(())
-}
@@ -0,0 +1,234 @@
+use crate::anim::{decode_animations, Animation};
+use crate::args::InspectArgs;
+use crate::config::{BadgeConfig, BoardConfig, Direction};
+use crate::file_names::{BADGES, BOARDS, HASH, LOCALES, META};
+use crate::locales::{decode_locales, Locale};
+use crate::output::{is_json, print_json};
+use crate::rom::open_rom;
+use crate::stats::{decode_badges, decode_boards};
+use crate::verify::{signature_status, verify_hash, SignatureStatus};
+use anyhow::{Context, Result};
+use colored::Colorize;
+use data_encoding::HEXLOWER;
+use firefly_meta::Meta;
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+
+/// Everything we know about the ROM.
+#[derive(Serialize)]
+struct RomInfo {
+    author_id:   String,
+    author_name: String,
+    app_id:      String,
+    app_name:    String,
+    version:     u32,
+    files:       Vec<FileInfo>,
+    total_size:  u64,
+    hash:        Option<String>,
+    hash_valid:  bool,
+    signature:   SignatureStatus,
+    badges:      Vec<BadgeConfig>,
+    boards:      Vec<BoardConfig>,
+    locales:     Vec<Locale>,
+    /// Animations of sliced images, keyed by the image file name.
+    animations:  BTreeMap<String, Vec<Animation>>,
+}
+
+#[derive(Serialize)]
+struct FileInfo {
+    name: String,
+    size: u64,
+}
+
+pub fn cmd_inspect(vfs: &Path, args: &InspectArgs) -> Result<()> {
+    let rom = open_rom(vfs, &args.target)?;
+    let info = inspect_dir(&rom.path)?;
+    if is_json() {
+        print_json(&info)?;
+    } else {
+        print_info(&info);
+    }
+    Ok(())
+}
+
+fn inspect_dir(rom_path: &Path) -> Result<RomInfo> {
+    let meta_raw = fs::read(rom_path.join(META)).context("read meta")?;
+    let meta = Meta::decode(&meta_raw).context("parse meta")?;
+
+    let mut files = Vec::new();
+    for entry in fs::read_dir(rom_path).context("read ROM dir")? {
+        let entry = entry.context("read ROM entry")?;
+        let file_meta = entry.metadata().context("get file metadata")?;
+        if !file_meta.is_file() {
+            continue;
+        }
+        files.push(FileInfo {
+            name: entry.file_name().to_string_lossy().to_string(),
+            size: file_meta.len(),
+        });
+    }
+    files.sort_by(|a, b| a.name.cmp(&b.name));
+    let total_size = files.iter().map(|f| f.size).sum();
+
+    let hash = fs::read(rom_path.join(HASH))
+        .ok()
+        .map(|h| HEXLOWER.encode(&h));
+    let hash_valid = verify_hash(rom_path).is_ok();
+    let signature = signature_status(rom_path);
+
+    let badges_path = rom_path.join(BADGES);
+    let badges = if badges_path.exists() {
+        let raw = fs::read(badges_path).context("read badges")?;
+        decode_badges(&raw).context("parse badges")?
+    } else {
+        Vec::new()
+    };
+
+    let boards_path = rom_path.join(BOARDS);
+    let boards = if boards_path.exists() {
+        let raw = fs::read(boards_path).context("read boards")?;
+        decode_boards(&raw).context("parse boards")?
+    } else {
+        Vec::new()
+    };
+
+    let mut animations = BTreeMap::new();
+    for file in &files {
+        let Some(image_name) = file.name.strip_suffix(".anim") else {
+            continue;
+        };
+        let raw = fs::read(rom_path.join(&file.name)).context("read animations")?;
+        let anims =
+            decode_animations(&raw).with_context(|| format!("parse animations of {image_name}"))?;
+        animations.insert(image_name.to_string(), anims);
+    }
+
+    let locales_path = rom_path.join(LOCALES);
+    let locales = if locales_path.exists() {
+        let raw = fs::read(locales_path).context("read locales")?;
+        decode_locales(&raw).context("parse locales")?
+    } else {
+        Vec::new()
+    };
+
+    Ok(RomInfo {
+        author_id: meta.author_id.to_string(),
+        author_name: meta.author_name.to_string(),
+        app_id: meta.app_id.to_string(),
+        app_name: meta.app_name.to_string(),
+        version: meta.version,
+        files,
+        total_size,
+        hash,
+        hash_valid,
+        signature,
+        badges,
+        boards,
+        locales,
+        animations,
+    })
+}
+
+fn print_info(info: &RomInfo) {
+    println!(
+        "{} {} ({})",
+        "author: ".cyan(),
+        info.author_name,
+        info.author_id
+    );
+    println!("{} {} ({})", "app:    ".cyan(), info.app_name, info.app_id);
+    println!("{} {}", "version:".cyan(), info.version);
+    let langs: Vec<_> = info
+        .locales
+        .iter()
+        .filter(|locale| !locale.lang.is_empty())
+        .map(|locale| locale.lang.as_str())
+        .collect();
+    if !langs.is_empty() {
+        println!("{} {}", "locales:".cyan(), langs.join(", "));
+    }
+    let hash = match (&info.hash, info.hash_valid) {
+        (Some(hash), true) => format!("{hash} {}", "(valid)".green()),
+        (Some(hash), false) => format!("{hash} {}", "(invalid)".red()),
+        (None, _) => "missing".red().to_string(),
+    };
+    println!("{} {hash}", "hash:   ".cyan());
+    let signature = match info.signature {
+        SignatureStatus::Valid => "valid".green(),
+        SignatureStatus::Invalid => "invalid".red(),
+        SignatureStatus::Missing => "missing".yellow(),
+    };
+    println!("{} {signature}", "signed: ".cyan());
+    if !info.badges.is_empty() {
+        println!("{}", "badges:".cyan());
+        for badge in &info.badges {
+            let hidden = if badge.hidden { " (hidden)" } else { "" };
+            println!("  {:16} {}{hidden}", badge.id, badge.name);
+        }
+    }
+    if !info.boards.is_empty() {
+        println!("{}", "boards:".cyan());
+        for board in &info.boards {
+            let direction = match board.direction {
+                Direction::HigherIsBetter => "higher is better",
+                Direction::LowerIsBetter => "lower is better",
+            };
+            println!("  {:16} {} ({direction})", board.id, board.title);
+        }
+    }
+    if !info.animations.is_empty() {
+        println!("{}", "animations:".cyan());
+        for (image_name, anims) in &info.animations {
+            let names: Vec<_> = anims.iter().map(|anim| anim.name.as_str()).collect();
+            println!("  {image_name:16} {}", names.join(", "));
+        }
+    }
+    println!("{}", "files:".cyan());
+    for file in &info.files {
+        println!("  {:16} {:>10}", file.name, file.size);
+    }
+    println!("  {:16} {:>10}", "total".bold(), info.total_size);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::crypto::hash_dir;
+    use crate::file_names::BIN;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_inspect_dir() {
+        let rom_path = make_tmp_dir();
+        let meta = Meta {
+            app_id:      "snek",
+            app_name:    "Snek",
+            author_id:   "greg",
+            author_name: "Greg",
+            launcher:    false,
+            sudo:        false,
+            version:     3,
+        };
+        let mut buf = vec![0; meta.size()];
+        let encoded = meta.encode(&mut buf).unwrap();
+        fs::write(rom_path.join(META), encoded).unwrap();
+        fs::write(rom_path.join(BIN), "hello").unwrap();
+        fs::write(rom_path.join(HASH), hash_dir(&rom_path).unwrap()).unwrap();
+
+        let info = inspect_dir(&rom_path).unwrap();
+        assert_eq!(info.author_id, "greg");
+        assert_eq!(info.app_name, "Snek");
+        assert_eq!(info.version, 3);
+        let names: Vec<_> = info.files.iter().map(|f| f.name.as_str()).collect();
+        assert_eq!(names, vec![BIN, HASH, META]);
+        assert_eq!(info.files[0].size, 5);
+        assert!(info.hash.is_some());
+        assert!(info.hash_valid);
+        assert!(matches!(info.signature, SignatureStatus::Missing));
+        assert!(info.badges.is_empty());
+        assert!(info.boards.is_empty());
+        assert!(info.locales.is_empty());
+    }
+}
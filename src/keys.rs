@@ -1,6 +1,8 @@
-use crate::args::{KeyArgs, KeyExportArgs};
+use crate::args::{KeyArgs, KeyExportArgs, KeyNewArgs};
+use crate::crypto::fingerprint;
 use crate::vfs::init_vfs;
 use anyhow::{bail, Context};
+use colored::Colorize;
 use rsa::pkcs1::{
     DecodeRsaPrivateKey, DecodeRsaPublicKey, EncodeRsaPrivateKey, EncodeRsaPublicKey,
 };
@@ -15,7 +17,7 @@ const BIT_SIZE: usize = 128;
 #[cfg(not(test))]
 const BIT_SIZE: usize = 2048;
 
-pub fn cmd_key_new(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
+pub fn cmd_key_new(vfs: &Path, args: &KeyNewArgs) -> anyhow::Result<()> {
     init_vfs(vfs).context("init vfs")?;
     let author = &args.author_id;
     if let Err(err) = firefly_meta::validate_id(author) {
@@ -26,11 +28,13 @@ pub fn cmd_key_new(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
     let sys_path = vfs.join("sys");
     let priv_path = sys_path.join("priv").join(author);
     let pub_path = sys_path.join("pub").join(author);
-    if priv_path.exists() {
-        bail!("the key pair for {author} already exists")
-    }
-    if pub_path.exists() {
-        bail!("the public key for {author} already exists")
+    if !args.force {
+        if priv_path.exists() {
+            bail!("the key pair for {author} already exists, use --force to overwrite it")
+        }
+        if pub_path.exists() {
+            bail!("the public key for {author} already exists, use --force to overwrite it")
+        }
     }
 
     // generate and save private key
@@ -38,11 +42,8 @@ pub fn cmd_key_new(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
     println!("⏳️ generating key pair...");
     let priv_key = RsaPrivateKey::new(&mut rng, BIT_SIZE).context("generate key")?;
     println!("⌛ saving keys...");
-    let mut priv_file = fs::File::create(priv_path)?;
     let priv_bytes = priv_key.to_pkcs1_der().context("serialize priv key")?;
-    priv_file
-        .write_all(priv_bytes.as_bytes())
-        .context("write priv key")?;
+    write_priv_key(&priv_path, priv_bytes.as_bytes()).context("write priv key")?;
 
     // save public key
     let pub_key = RsaPublicKey::from(&priv_key);
@@ -52,7 +53,57 @@ pub fn cmd_key_new(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
         .write_all(pub_bytes.as_bytes())
         .context("write pub key")?;
 
-    println!("✅ generated key pair for {author}");
+    let fp = fingerprint(pub_bytes.as_bytes());
+    println!("✅ generated key pair for {author} ({fp})");
+    Ok(())
+}
+
+/// Write the private key into a file that only the current user can access.
+fn write_priv_key(path: &Path, raw_key: &[u8]) -> anyhow::Result<()> {
+    // The old file might have wider permissions which won't be changed
+    // when opening it for writing, so it must be removed first.
+    if path.exists() {
+        fs::remove_file(path).context("remove old key")?;
+    }
+    let mut options = fs::OpenOptions::new();
+    options.write(true).create_new(true);
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::OpenOptionsExt;
+        options.mode(0o600);
+    }
+    let mut file = options.open(path).context("create key file")?;
+    file.write_all(raw_key).context("write key file")?;
+    Ok(())
+}
+
+pub fn cmd_key_list(vfs: &Path) -> anyhow::Result<()> {
+    let pub_dir = vfs.join("sys").join("pub");
+    let priv_dir = vfs.join("sys").join("priv");
+    let mut authors = Vec::new();
+    if pub_dir.exists() {
+        for entry in fs::read_dir(&pub_dir).context("read public keys dir")? {
+            let entry = entry.context("read dir entry")?;
+            if let Ok(author) = entry.file_name().into_string() {
+                authors.push(author);
+            }
+        }
+    }
+    if authors.is_empty() {
+        println!("⚠️  no keys found");
+        return Ok(());
+    }
+    authors.sort();
+    for author in authors {
+        let raw_key = fs::read(pub_dir.join(&author)).context("read public key")?;
+        let fp = fingerprint(&raw_key);
+        let kind = if priv_dir.join(&author).exists() {
+            "key pair".green()
+        } else {
+            "public".cyan()
+        };
+        println!("{author:16} {fp}  {kind}");
+    }
     Ok(())
 }
 
@@ -85,7 +136,12 @@ pub fn export_key(vfs: &Path, args: &KeyExportArgs, public: bool) -> anyhow::Res
         if !key_path.exists() {
             bail!("{key_type} key for {author} not found");
         }
-        fs::copy(key_path, output_path).context("copy key")?;
+        if public {
+            fs::copy(key_path, output_path).context("copy key")?;
+        } else {
+            let raw_key = fs::read(key_path).context("read key")?;
+            write_priv_key(output_path, &raw_key)?;
+        }
     }
 
     // make the file read-only (if possible)
@@ -130,7 +186,7 @@ pub fn cmd_key_rm(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
     Ok(())
 }
 
-pub fn cmd_key_add(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
+pub fn cmd_key_add(vfs: &Path, args: &KeyNewArgs) -> anyhow::Result<()> {
     init_vfs(vfs).context("init vfs")?;
     let key_path = &args.author_id;
     let (author, raw_key) = if key_path.starts_with("https://") {
@@ -151,6 +207,13 @@ pub fn cmd_key_add(vfs: &Path, args: &KeyArgs) -> anyhow::Result<()> {
     } else {
         bail!("the key file not found")
     };
+    if let Err(err) = firefly_meta::validate_id(&author) {
+        bail!("invalid author ID: {err}")
+    }
+    let pub_path = vfs.join("sys").join("pub").join(&author);
+    if pub_path.exists() && !args.force {
+        bail!("the key for {author} already exists, use --force to overwrite it")
+    }
     save_raw_key(vfs, &author, &raw_key)?;
     println!("✅ added new key");
     Ok(())
@@ -185,7 +248,7 @@ fn save_raw_key(vfs: &Path, author: &str, raw_key: &[u8]) -> anyhow::Result<()>
     let pub_path = sys_path.join("pub").join(author);
     if let Ok(key) = RsaPrivateKey::from_pkcs1_der(raw_key) {
         let path = sys_path.join("priv").join(author);
-        fs::write(path, raw_key).context("write private key")?;
+        write_priv_key(&path, raw_key).context("write private key")?;
 
         // generate and save public key
         let key = key.to_public_key();
@@ -207,8 +270,9 @@ mod tests {
     #[test]
     fn test_cmd_key_new() {
         let vfs = make_tmp_vfs();
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: "greg".to_string(),
+            force:     false,
         };
         cmd_key_new(&vfs, &args).unwrap();
         let key_path = vfs.join("sys").join("priv").join("greg");
@@ -217,11 +281,50 @@ mod tests {
         assert!(key_path.is_file());
     }
 
+    #[test]
+    fn test_cmd_key_new_force() {
+        let vfs = make_tmp_vfs();
+        let mut args = KeyNewArgs {
+            author_id: "greg".to_string(),
+            force:     false,
+        };
+        cmd_key_new(&vfs, &args).unwrap();
+        assert!(cmd_key_new(&vfs, &args).is_err());
+        args.force = true;
+        cmd_key_new(&vfs, &args).unwrap();
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_priv_key_permissions() {
+        use std::os::unix::fs::PermissionsExt;
+        let vfs = make_tmp_vfs();
+        let args = KeyNewArgs {
+            author_id: "greg".to_string(),
+            force:     false,
+        };
+        cmd_key_new(&vfs, &args).unwrap();
+        let key_path = vfs.join("sys").join("priv").join("greg");
+        let mode = key_path.metadata().unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+
+        // The exported private key is not readable by others either.
+        let export_path = vfs.join("greg.der");
+        let args = KeyExportArgs {
+            author_id: "greg".to_string(),
+            output:    Some(export_path.clone()),
+        };
+        cmd_key_priv(&vfs, &args).unwrap();
+        let mode = export_path.metadata().unwrap().permissions().mode();
+        assert_eq!(mode & 0o077, 0);
+    }
+
     #[test]
     fn test_cmd_key_pub() {
         let vfs = make_tmp_vfs();
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: "greg".to_string(),
+            force:     false,
         };
         cmd_key_new(&vfs, &args).unwrap();
 
@@ -239,8 +342,9 @@ mod tests {
     #[test]
     fn test_cmd_key_priv() {
         let vfs = make_tmp_vfs();
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: "greg".to_string(),
+            force:     false,
         };
         cmd_key_new(&vfs, &args).unwrap();
 
@@ -259,8 +363,9 @@ mod tests {
     #[test]
     fn test_cmd_key_add_pub() {
         let vfs = make_tmp_vfs();
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: "greg".to_string(),
+            force:     false,
         };
 
         // create key
@@ -289,8 +394,9 @@ mod tests {
         assert!(!key_path.exists());
 
         // import key from file
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: export_path.to_str().unwrap().to_string(),
+            force:     false,
         };
         cmd_key_add(&vfs, &args).unwrap();
         let key_path = vfs.join("sys").join("priv").join("greg");
@@ -302,8 +408,9 @@ mod tests {
     #[test]
     fn test_cmd_key_add_priv() {
         let vfs = make_tmp_vfs();
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: "greg".to_string(),
+            force:     false,
         };
 
         // create key
@@ -332,8 +439,9 @@ mod tests {
         assert!(!key_path.exists());
 
         // import key from file
-        let args = KeyArgs {
+        let args = KeyNewArgs {
             author_id: export_path.to_str().unwrap().to_string(),
+            force:     false,
         };
         cmd_key_add(&vfs, &args).unwrap();
         let key_path = vfs.join("sys").join("priv").join("greg");
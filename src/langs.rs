@@ -1,8 +1,9 @@
 use crate::args::BuildArgs;
 use crate::config::{Config, Lang};
 use crate::file_names::BIN;
-use crate::wasm::{optimize, strip_custom};
+use crate::wasm::{check_imports, optimize, strip_custom};
 use anyhow::{bail, Context};
+use clap::ValueEnum;
 use std::env::temp_dir;
 use std::fs::File;
 use std::io::Write;
@@ -30,51 +31,78 @@ pub fn build_bin(config: &Config, args: &BuildArgs) -> anyhow::Result<()> {
         Lang::Python => build_python(config),
     }?;
     let bin_path = config.rom_path.join(BIN);
-    if !args.no_strip {
+    let strip = args.release || (!args.no_strip && config.build.strip.unwrap_or(true));
+    let opt = args.release || (!args.no_opt && config.build.optimize.unwrap_or(true));
+    let size_before = file_size(&bin_path);
+    if strip {
         strip_custom(&bin_path)?;
     }
-    if !args.no_opt {
+    if opt {
         optimize(&bin_path).context("optimize wasm binary")?;
     }
+    if strip || opt {
+        let size_after = file_size(&bin_path);
+        let saved = size_before.saturating_sub(size_after);
+        eprintln!("binary size: {size_before} -> {size_after} bytes (saved {saved} bytes)");
+    }
+    if !args.no_check_imports && config.build.check_imports.unwrap_or(true) {
+        check_imports(&bin_path).context("check wasm imports")?;
+    }
     Ok(())
 }
 
-fn detect_lang(root: &Path) -> anyhow::Result<Lang> {
-    if root.join("go.mod").exists() {
-        return Ok(Lang::Go);
-    }
-    if root.join("Cargo.toml").exists() {
-        return Ok(Lang::Rust);
-    }
-    // Rust examples don't have Cargo.toml
-    if root.join("main.rs").exists() {
-        return Ok(Lang::Rust);
-    }
-    if root.join("build.zig").exists() {
-        return Ok(Lang::Zig);
-    }
-    if root.join("build.zig.zon").exists() {
-        return Ok(Lang::Zig);
-    }
-    if root.join("package.json").exists() {
-        return Ok(Lang::TS);
-    }
-    if root.join("pyproject.toml").exists() {
-        return Ok(Lang::Python);
-    }
-    if root.join("main.c").exists() {
-        return Ok(Lang::C);
-    }
-    if root.join("main.cpp").exists() {
-        return Ok(Lang::Cpp);
+/// Check if `build` can compile projects in the given language.
+pub const fn is_supported(lang: &Lang) -> bool {
+    !matches!(lang, Lang::TS | Lang::Python)
+}
+
+/// Get the file size in bytes or zero if the file cannot be accessed.
+fn file_size(path: &Path) -> u64 {
+    std::fs::metadata(path).map_or(0, |meta| meta.len())
+}
+
+/// Detect the programming language of the project based on the files in its root.
+///
+/// If files of multiple languages are found, the first marker in the list wins
+/// and a warning is shown.
+pub fn detect_lang(root: &Path) -> anyhow::Result<Lang> {
+    let markers = [
+        ("go.mod", Lang::Go),
+        ("Cargo.toml", Lang::Rust),
+        // Rust examples don't have Cargo.toml
+        ("main.rs", Lang::Rust),
+        ("build.zig", Lang::Zig),
+        ("build.zig.zon", Lang::Zig),
+        ("package.json", Lang::TS),
+        ("pyproject.toml", Lang::Python),
+        ("main.c", Lang::C),
+        ("main.cpp", Lang::Cpp),
+        ("src/main.c", Lang::C),
+        ("src/main.cpp", Lang::Cpp),
+    ];
+    let mut langs: Vec<Lang> = Vec::new();
+    for (marker, lang) in markers {
+        if root.join(marker).exists() && !langs.contains(&lang) {
+            langs.push(lang);
+        }
     }
-    if root.join("src").join("main.c").exists() {
-        return Ok(Lang::C);
+    if langs.is_empty() {
+        bail!("failed to detect the programming language, specify it using --lang");
     }
-    if root.join("src").join("main.cpp").exists() {
-        return Ok(Lang::Cpp);
+    if langs.len() > 1 {
+        let names: Vec<_> = langs
+            .iter()
+            .filter_map(ValueEnum::to_possible_value)
+            .map(|v| v.get_name().to_string())
+            .collect();
+        eprintln!(
+            "⚠️  detected multiple programming languages ({}), using {}. \
+            Specify one using --lang or `lang` in firefly.toml",
+            names.join(", "),
+            names[0],
+        );
     }
-    bail!("failed to detect the programming language");
+    Ok(langs.remove(0))
 }
 
 /// Build Go code using [TinyGo].
@@ -255,17 +283,54 @@ fn build_cpp_inner(config: &Config, fname: &str) -> anyhow::Result<()> {
     Ok(())
 }
 
-fn build_zig(_config: &Config) -> anyhow::Result<()> {
+/// Build Zig project using `zig build`.
+///
+/// The project must be configured to produce a single wasm binary in zig-out/bin.
+fn build_zig(config: &Config) -> anyhow::Result<()> {
     check_installed("Zig", "zig", "version")?;
-    todo!("Zig is not supported yet")
+    let mut cmd_args = vec!["build", "-Doptimize=ReleaseSmall"];
+    if let Some(additional_args) = &config.compile_args {
+        for arg in additional_args {
+            cmd_args.push(arg.as_str());
+        }
+    }
+    let output = Command::new("zig")
+        .args(cmd_args)
+        .current_dir(&config.root_path)
+        .output()
+        .context("run zig build")?;
+    check_output(&output)?;
+
+    let from_path = find_zig_result(&config.root_path)?;
+    let out_path = config.rom_path.join(BIN);
+    std::fs::copy(from_path, out_path).context("copy wasm binary")?;
+    Ok(())
+}
+
+/// Locate the wasm binary produced by `zig build`.
+fn find_zig_result(root: &Path) -> anyhow::Result<PathBuf> {
+    let bin_dir = root.join("zig-out").join("bin");
+    let entries = std::fs::read_dir(&bin_dir).context("read zig-out/bin directory")?;
+    let mut found = Vec::new();
+    for entry in entries {
+        let path = entry?.path();
+        if path.extension().is_some_and(|ext| ext == "wasm") {
+            found.push(path);
+        }
+    }
+    match found.len() {
+        0 => bail!("cannot find wasm binary in zig-out/bin"),
+        1 => Ok(found.remove(0)),
+        _ => bail!("found multiple wasm binaries in zig-out/bin"),
+    }
 }
 
 fn build_ts(_config: &Config) -> anyhow::Result<()> {
-    todo!("TypeScript is not supported yet")
+    bail!("TypeScript is not supported yet")
 }
 
 fn build_python(_config: &Config) -> anyhow::Result<()> {
-    todo!("Python is not supported yet")
+    bail!("Python is not supported yet")
 }
 
 /// Convert a file system path to UTF-8 if possible.
@@ -277,7 +342,7 @@ fn path_to_utf8(path: &Path) -> anyhow::Result<&str> {
 }
 
 fn check_output(output: &Output) -> anyhow::Result<()> {
-    std::io::stdout().write_all(&output.stdout)?;
+    std::io::stderr().write_all(&output.stdout)?;
     std::io::stderr().write_all(&output.stderr)?;
     if !output.status.success() {
         let code = output.status.code().unwrap_or(1);
@@ -289,16 +354,42 @@ fn check_output(output: &Output) -> anyhow::Result<()> {
 /// Run the given binary with the given arg and return an error if it is not installed.
 fn check_installed(lang: &str, bin: &str, arg: &str) -> anyhow::Result<()> {
     let output = Command::new(bin).args([arg]).output();
-    if let Ok(output) = output {
-        if output.status.success() {
-            return Ok(());
-        }
+    let problem = match output {
+        Ok(output) if output.status.success() => return Ok(()),
+        Ok(_) => format!("{bin} is installed but `{bin} {arg}` failed"),
+        Err(_) => format!("{bin} not found in PATH"),
+    };
+    bail!(
+        "{problem}.\n\
+        You're trying to build a {lang} app but you don't have {bin} installed.\n\
+        Please, follow the getting started guide for {lang}:\n  \
+        https://docs.fireflyzero.com/dev/getting-started/"
+    );
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_detect_lang() {
+        let root = make_tmp_dir();
+        assert!(detect_lang(&root).is_err());
+        std::fs::write(root.join("go.mod"), "").unwrap();
+        assert_eq!(detect_lang(&root).unwrap(), Lang::Go);
+        std::fs::write(root.join("Cargo.toml"), "").unwrap();
+        assert_eq!(detect_lang(&root).unwrap(), Lang::Go);
+        std::fs::remove_file(root.join("go.mod")).unwrap();
+        std::fs::write(root.join("main.rs"), "").unwrap();
+        assert_eq!(detect_lang(&root).unwrap(), Lang::Rust);
+    }
+
+    #[test]
+    fn test_check_installed() {
+        let err = check_installed("Go", "surely-not-installed-binary", "version").unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("surely-not-installed-binary not found in PATH."));
     }
-    let mut msg =
-        format!("You're trying to build a {lang} app but you don't have {bin} installed.\n");
-    msg.push_str(&format!(
-        "Please, follow the getting started guide for {lang}:\n"
-    ));
-    msg.push_str("  https://docs.fireflyzero.com/dev/getting-started/");
-    bail!(msg);
 }
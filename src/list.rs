@@ -0,0 +1,114 @@
+use crate::args::{ListArgs, SortBy};
+use crate::file_names::META;
+use crate::output::{is_json, print_json};
+use crate::verify::{signature_status, SignatureStatus};
+use crate::vfs::{dir_size, list_apps};
+use anyhow::{Context, Result};
+use colored::Colorize;
+use firefly_meta::Meta;
+use serde::Serialize;
+use std::fs;
+use std::path::Path;
+
+/// Short info about an installed app.
+#[derive(Serialize)]
+struct AppInfo {
+    id:        String,
+    name:      String,
+    version:   u32,
+    size:      u64,
+    signature: SignatureStatus,
+}
+
+pub fn cmd_list(vfs: &Path, args: &ListArgs) -> Result<()> {
+    let mut apps = Vec::new();
+    for (author_id, app_id) in list_apps(vfs)? {
+        let rom_path = vfs.join("roms").join(&author_id).join(&app_id);
+        let id = format!("{author_id}.{app_id}");
+        let info = load_app_info(&rom_path, id)
+            .with_context(|| format!("read info for {author_id}.{app_id}"))?;
+        apps.push(info);
+    }
+    sort_apps(&mut apps, args.sort);
+    if is_json() {
+        return print_json(&apps);
+    }
+    if apps.is_empty() {
+        println!("⚠️  no apps installed");
+        return Ok(());
+    }
+    print_table(&apps);
+    Ok(())
+}
+
+fn load_app_info(rom_path: &Path, id: String) -> Result<AppInfo> {
+    let meta_raw = fs::read(rom_path.join(META)).context("read meta")?;
+    let meta = Meta::decode(&meta_raw).context("parse meta")?;
+    Ok(AppInfo {
+        id,
+        name: meta.app_name.to_string(),
+        version: meta.version,
+        size: dir_size(rom_path),
+        signature: signature_status(rom_path),
+    })
+}
+
+/// Sort by name ascending or by size descending.
+fn sort_apps(apps: &mut [AppInfo], sort: SortBy) {
+    match sort {
+        SortBy::Name => apps.sort_by(|a, b| a.id.cmp(&b.id)),
+        SortBy::Size => apps.sort_by(|a, b| b.size.cmp(&a.size).then(a.id.cmp(&b.id))),
+    }
+}
+
+fn print_table(apps: &[AppInfo]) {
+    println!(
+        "{:24} {:24} {:>7} {:>10}  {}",
+        "ID".bold(),
+        "NAME".bold(),
+        "VERSION".bold(),
+        "SIZE".bold(),
+        "SIGNED".bold(),
+    );
+    for app in apps {
+        let signed = match app.signature {
+            SignatureStatus::Valid => "yes".green(),
+            SignatureStatus::Invalid => "invalid".red(),
+            SignatureStatus::Missing => "no".yellow(),
+        };
+        println!(
+            "{:24} {:24} {:>7} {:>10}  {signed}",
+            app.id, app.name, app.version, app.size
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn make_info(id: &str, size: u64) -> AppInfo {
+        AppInfo {
+            id: id.to_string(),
+            name: String::new(),
+            version: 1,
+            size,
+            signature: SignatureStatus::Missing,
+        }
+    }
+
+    #[test]
+    fn test_sort_apps() {
+        let mut apps = vec![
+            make_info("b.b", 10),
+            make_info("a.a", 5),
+            make_info("c.c", 20),
+        ];
+        sort_apps(&mut apps, SortBy::Name);
+        let ids: Vec<_> = apps.iter().map(|a| a.id.as_str()).collect();
+        assert_eq!(ids, vec!["a.a", "b.b", "c.c"]);
+        sort_apps(&mut apps, SortBy::Size);
+        let ids: Vec<_> = apps.iter().map(|a| a.id.as_str()).collect();
+        assert_eq!(ids, vec!["c.c", "b.b", "a.a"]);
+    }
+}
@@ -0,0 +1,163 @@
+use crate::binary::{write_len, write_str, Reader};
+use crate::config::{Config, LocaleConfig};
+use crate::file_names::LOCALES;
+use anyhow::{bail, Context, Result};
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::fs;
+
+const MAX_DESCRIPTION_LEN: usize = 256;
+
+/// ISO 639-1 language codes.
+const LANGUAGES: &[&str] = &[
+    "aa", "ab", "ae", "af", "ak", "am", "an", "ar", "as", "av", "ay", "az", "ba", "be", "bg", "bh",
+    "bi", "bm", "bn", "bo", "br", "bs", "ca", "ce", "ch", "co", "cr", "cs", "cu", "cv", "cy", "da",
+    "de", "dv", "dz", "ee", "el", "en", "eo", "es", "et", "eu", "fa", "ff", "fi", "fj", "fo", "fr",
+    "fy", "ga", "gd", "gl", "gn", "gu", "gv", "ha", "he", "hi", "ho", "hr", "ht", "hu", "hy", "hz",
+    "ia", "id", "ie", "ig", "ii", "ik", "io", "is", "it", "iu", "ja", "jv", "ka", "kg", "ki", "kj",
+    "kk", "kl", "km", "kn", "ko", "kr", "ks", "ku", "kv", "kw", "ky", "la", "lb", "lg", "li", "ln",
+    "lo", "lt", "lu", "lv", "mg", "mh", "mi", "mk", "ml", "mn", "mr", "ms", "mt", "my", "na", "nb",
+    "nd", "ne", "ng", "nl", "nn", "no", "nr", "nv", "ny", "oc", "oj", "om", "or", "os", "pa", "pi",
+    "pl", "ps", "pt", "qu", "rm", "rn", "ro", "ru", "rw", "sa", "sc", "sd", "se", "sg", "si", "sk",
+    "sl", "sm", "sn", "so", "sq", "sr", "ss", "st", "su", "sv", "sw", "ta", "te", "tg", "th", "ti",
+    "tk", "tl", "tn", "to", "tr", "ts", "tt", "tw", "ty", "ug", "uk", "ur", "uz", "ve", "vi", "vo",
+    "wa", "wo", "xh", "yi", "yo", "za", "zh", "zu",
+];
+
+/// The app name and description in one language.
+#[derive(Serialize, Debug, PartialEq, Eq)]
+pub struct Locale {
+    /// The language code. Empty for the default.
+    pub lang:        String,
+    pub app_name:    String,
+    pub description: String,
+}
+
+/// Validate the translations from the config and write them into the ROM.
+pub fn write_locales(config: &Config) -> Result<()> {
+    let empty = BTreeMap::new();
+    let locales = config.locales.as_ref().unwrap_or(&empty);
+    let description = config.description.as_deref();
+    let locales = collect_locales(&config.app_name, description, locales)?;
+    let raw = encode_locales(&locales)?;
+    let output_path = config.rom_path.join(LOCALES);
+    fs::write(output_path, raw).context("write locales file")?;
+    Ok(())
+}
+
+/// Validate the locales and fill the missing fields with the defaults.
+///
+/// The first item is always the default locale.
+fn collect_locales(
+    app_name: &str,
+    description: Option<&str>,
+    locales: &BTreeMap<String, LocaleConfig>,
+) -> Result<Vec<Locale>> {
+    if let Some(description) = description {
+        if description.len() > MAX_DESCRIPTION_LEN {
+            bail!("description is longer than {MAX_DESCRIPTION_LEN} bytes");
+        }
+    }
+    let mut result = vec![Locale {
+        lang:        String::new(),
+        app_name:    app_name.to_string(),
+        description: description.unwrap_or_default().to_string(),
+    }];
+    for (lang, locale) in locales {
+        if !LANGUAGES.contains(&lang.as_str()) {
+            eprintln!("⚠️  unknown language code in locales: {lang}");
+        }
+        if let Some(app_name) = &locale.app_name {
+            if let Err(err) = firefly_meta::validate_name(app_name) {
+                bail!("locale {lang}: invalid app_name: {err}");
+            }
+        }
+        if let Some(local) = &locale.description {
+            if description.is_none() {
+                bail!("locale {lang}: description is set but the default description is missing");
+            }
+            if local.len() > MAX_DESCRIPTION_LEN {
+                bail!("locale {lang}: description is longer than {MAX_DESCRIPTION_LEN} bytes");
+            }
+        }
+        result.push(Locale {
+            lang:        lang.clone(),
+            app_name:    locale.app_name.as_deref().unwrap_or(app_name).to_string(),
+            description: locale
+                .description
+                .as_deref()
+                .or(description)
+                .unwrap_or_default()
+                .to_string(),
+        });
+    }
+    Ok(result)
+}
+
+/// Serialize the locales.
+///
+/// The format is the number of locales (u16) followed by the locales.
+/// Each locale is the language code (empty for the default), the app name,
+/// and the description, each is a string prefixed by its length (u16).
+fn encode_locales(locales: &[Locale]) -> Result<Vec<u8>> {
+    let mut raw = Vec::new();
+    write_len(&mut raw, locales.len())?;
+    for locale in locales {
+        write_str(&mut raw, &locale.lang)?;
+        write_str(&mut raw, &locale.app_name)?;
+        write_str(&mut raw, &locale.description)?;
+    }
+    Ok(raw)
+}
+
+/// Parse the locales file written by [`write_locales`].
+pub fn decode_locales(raw: &[u8]) -> Result<Vec<Locale>> {
+    let mut reader = Reader::new(raw);
+    let count = reader.read_u16()?;
+    let mut locales = Vec::new();
+    for _ in 0..count {
+        locales.push(Locale {
+            lang:        reader.read_str()?,
+            app_name:    reader.read_str()?,
+            description: reader.read_str()?,
+        });
+    }
+    Ok(locales)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_collect_locales() {
+        let mut locales = BTreeMap::new();
+        locales.insert(
+            "fr".to_string(),
+            LocaleConfig {
+                app_name:    Some("Serpent".to_string()),
+                description: None,
+            },
+        );
+        locales.insert(
+            "xx".to_string(),
+            LocaleConfig {
+                app_name:    None,
+                description: Some("in a language from the future".to_string()),
+            },
+        );
+        let result = collect_locales("Snek", Some("eat apples"), &locales).unwrap();
+        assert_eq!(result.len(), 3);
+        assert_eq!(result[0].lang, "");
+        assert_eq!(result[1].lang, "fr");
+        assert_eq!(result[1].app_name, "Serpent");
+        assert_eq!(result[1].description, "eat apples");
+        assert_eq!(result[2].app_name, "Snek");
+
+        let raw = encode_locales(&result).unwrap();
+        assert_eq!(decode_locales(&raw).unwrap(), result);
+
+        // a description can't be translated if there is no default
+        assert!(collect_locales("Snek", None, &locales).is_err());
+    }
+}
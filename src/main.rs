@@ -2,27 +2,60 @@
 #![allow(clippy::module_name_repetitions)]
 #![allow(clippy::option_if_let_else)]
 
+mod anim;
 mod args;
+mod aseprite;
+mod atlas;
+mod binary;
+mod budget;
 mod build;
+mod cache;
+mod codegen;
 mod config;
 mod crypto;
+mod diff;
+mod doctor;
+mod du;
 mod export;
 mod file_names;
 mod images;
 mod import;
+mod inspect;
 mod keys;
 mod langs;
+mod list;
+mod locales;
+mod new;
+mod output;
+mod progress;
+mod rom;
+mod settings;
+mod stats;
+mod tilemap;
+mod uninstall;
+mod verify;
 mod vfs;
 mod wasm;
+mod watch;
 
 #[cfg(test)]
 mod test_helpers;
 
-use crate::args::{Cli, Commands, KeyCommands};
+use crate::args::{Cli, Commands, ConfigCommands, KeyCommands};
 use crate::build::cmd_build;
+use crate::diff::cmd_diff;
+use crate::doctor::cmd_doctor;
+use crate::du::cmd_du;
 use crate::export::cmd_export;
 use crate::import::cmd_import;
-use crate::keys::{cmd_key_add, cmd_key_new, cmd_key_priv, cmd_key_pub, cmd_key_rm};
+use crate::inspect::cmd_inspect;
+use crate::keys::{cmd_key_add, cmd_key_list, cmd_key_new, cmd_key_priv, cmd_key_pub, cmd_key_rm};
+use crate::list::cmd_list;
+use crate::new::cmd_new;
+use crate::output::{print_json_error, set_json, set_quiet, Reported};
+use crate::settings::{cmd_config_get, cmd_config_list, cmd_config_set};
+use crate::uninstall::cmd_uninstall;
+use crate::verify::cmd_verify;
 use crate::vfs::{cmd_vfs, get_vfs_path};
 use clap::Parser;
 use colored::Colorize;
@@ -30,6 +63,8 @@ use std::fmt::Display;
 
 fn main() {
     let cli = Cli::parse();
+    set_json(cli.json);
+    set_quiet(cli.quiet);
     let vfs = get_vfs_path();
     let res: anyhow::Result<()> = match &cli.command {
         Commands::Build(args) => cmd_build(vfs, args),
@@ -37,12 +72,31 @@ fn main() {
         Commands::Import(args) => cmd_import(&vfs, args),
         Commands::Key(KeyCommands::New(args)) => cmd_key_new(&vfs, args),
         Commands::Key(KeyCommands::Add(args)) => cmd_key_add(&vfs, args),
+        Commands::Key(KeyCommands::List) => cmd_key_list(&vfs),
         Commands::Key(KeyCommands::Pub(args)) => cmd_key_pub(&vfs, args),
         Commands::Key(KeyCommands::Priv(args)) => cmd_key_priv(&vfs, args),
         Commands::Key(KeyCommands::Rm(args)) => cmd_key_rm(&vfs, args),
+        Commands::Config(ConfigCommands::Set(args)) => cmd_config_set(args),
+        Commands::Config(ConfigCommands::Get(args)) => cmd_config_get(args),
+        Commands::Config(ConfigCommands::List) => cmd_config_list(),
+        Commands::New(args) => cmd_new(args),
+        Commands::List(args) => cmd_list(&vfs, args),
+        Commands::Diff(args) => cmd_diff(&vfs, args),
+        Commands::Doctor(args) => cmd_doctor(&vfs, args),
+        Commands::Du => cmd_du(&vfs),
+        Commands::Inspect(args) => cmd_inspect(&vfs, args),
+        Commands::Uninstall(args) => cmd_uninstall(&vfs, args),
+        Commands::Verify(args) => cmd_verify(&vfs, args),
         Commands::Vfs => cmd_vfs(),
     };
     if let Err(err) = res {
+        if err.is::<Reported>() {
+            std::process::exit(1);
+        }
+        if cli.json {
+            print_json_error(&Error(err).to_string());
+            std::process::exit(1);
+        }
         eprintln!("{} {}", "💥 Error:".red(), Error(err));
         std::process::exit(1);
     }
@@ -0,0 +1,332 @@
+use crate::args::NewArgs;
+use crate::config::Lang;
+use crate::langs::is_supported;
+use crate::settings::Settings;
+use anyhow::{bail, Context, Result};
+use clap::ValueEnum;
+use std::fs;
+use std::io::{BufRead, IsTerminal, Write};
+use std::path::Path;
+
+/// A starter project for a language.
+struct Template {
+    name:        &'static str,
+    description: &'static str,
+    /// The languages the template is available for. Empty means all languages.
+    langs:       &'static [Lang],
+    /// The files to create, relative to the project root.
+    files:       fn(&Lang) -> Vec<(&'static str, &'static str)>,
+}
+
+const TEMPLATES: &[Template] = &[
+    Template {
+        name:        "minimal",
+        description: "the smallest app that draws something on the screen",
+        langs:       &[Lang::Go, Lang::Rust],
+        files:       minimal_files,
+    },
+    Template {
+        name:        "blank",
+        description: "only firefly.toml, bring your own code",
+        langs:       &[],
+        files:       |_| vec![],
+    },
+];
+
+const FIREFLY_TOML: &str = r#"author_id = "{{author_id}}"
+app_id = "{{app_id}}"
+author_name = "{{author_name}}"
+app_name = "{{app_name}}"
+lang = "{{lang}}"
+"#;
+
+// The minimal templates call the runtime host functions directly
+// (see `HOST_FUNCS` in wasm.rs) instead of going through an SDK,
+// so that they don't break when the SDK API changes.
+// Colors are indices in the default palette: 10 is blue and 13 is white.
+
+const GO_MOD: &str = "module {{app_id}}
+
+go 1.22
+";
+
+const GO_MAIN: &str = "package main
+
+//go:wasmimport graphics clear_screen
+func clearScreen(color int32)
+
+//go:wasmimport graphics draw_circle
+func drawCircle(x, y, diameter, fillColor, strokeColor, strokeWidth int32)
+
+const (
+	blue  int32 = 10
+	white int32 = 13
+)
+
+//export render
+func render() {
+	clearScreen(white)
+	drawCircle(100, 60, 40, blue, 0, 0)
+}
+
+func main() {}
+";
+
+const RUST_CARGO: &str = r#"[package]
+name = "{{app_id}}"
+version = "0.1.0"
+edition = "2021"
+"#;
+
+const RUST_MAIN: &str = "#![no_main]
+
+#[link(wasm_import_module = \"graphics\")]
+extern \"C\" {
+    fn clear_screen(color: i32);
+    fn draw_circle(x: i32, y: i32, diameter: i32, fill: i32, stroke: i32, width: i32);
+}
+
+const BLUE: i32 = 10;
+const WHITE: i32 = 13;
+
+#[no_mangle]
+extern \"C\" fn render() {
+    unsafe {
+        clear_screen(WHITE);
+        draw_circle(100, 60, 40, BLUE, 0, 0);
+    }
+}
+";
+
+const RUST_GITIGNORE: &str = "/target\n";
+
+fn minimal_files(lang: &Lang) -> Vec<(&'static str, &'static str)> {
+    match lang {
+        Lang::Go => vec![("go.mod", GO_MOD), ("main.go", GO_MAIN)],
+        Lang::Rust => vec![
+            ("Cargo.toml", RUST_CARGO),
+            ("src/main.rs", RUST_MAIN),
+            (".gitignore", RUST_GITIGNORE),
+        ],
+        _ => vec![],
+    }
+}
+
+pub fn cmd_new(args: &NewArgs) -> Result<()> {
+    if let Err(err) = firefly_meta::validate_id(&args.name) {
+        bail!("invalid app ID: {err}");
+    }
+    let root = Path::new(&args.name);
+    if is_non_empty_dir(root) && !args.force {
+        bail!(
+            "the directory {} is not empty, use --force to write into it anyway",
+            args.name
+        );
+    }
+    let interactive = std::io::stdin().is_terminal();
+
+    let lang = match &args.lang {
+        Some(lang) => lang.clone(),
+        None if interactive => ask_lang()?,
+        None => bail!("--lang is required when not running interactively"),
+    };
+    if !is_supported(&lang) {
+        bail!("{} is not supported by the build yet", lang_name(&lang));
+    }
+    let template = match &args.template {
+        Some(name) => find_template(name, &lang)?,
+        None if interactive => ask_template(&lang)?,
+        None => bail!("--template is required when not running interactively"),
+    };
+    let default_author = Settings::load()?.author;
+    let author_id = match (&args.author, default_author) {
+        (Some(author_id), _) => author_id.clone(),
+        (None, default_author) if interactive => {
+            ask("author ID", default_author.as_deref().unwrap_or("joearms"))?
+        }
+        (None, Some(author_id)) => author_id,
+        (None, None) => bail!(
+            "--author is required when not running interactively, \
+            or set the default author with `firefly_cli config set author <ID>`"
+        ),
+    };
+    if let Err(err) = firefly_meta::validate_id(&author_id) {
+        bail!("invalid author ID: {err}");
+    }
+
+    scaffold(root, &author_id, &args.name, &lang, template)?;
+    println!("✅ created project {} in {}/", args.name, args.name);
+    println!("💡 to build and install the app, run:");
+    println!("  cd {}", args.name);
+    println!("  firefly_cli build");
+    Ok(())
+}
+
+/// Write all files of the template into the project root.
+fn scaffold(
+    root: &Path,
+    author_id: &str,
+    app_id: &str,
+    lang: &Lang,
+    template: &Template,
+) -> Result<()> {
+    let lang_name = lang_name(lang);
+    let substitute = |content: &str| {
+        content
+            .replace("{{author_id}}", author_id)
+            .replace("{{author_name}}", &make_name(author_id))
+            .replace("{{app_id}}", app_id)
+            .replace("{{app_name}}", &make_name(app_id))
+            .replace("{{lang}}", &lang_name)
+    };
+    let mut files = (template.files)(lang);
+    files.push(("firefly.toml", FIREFLY_TOML));
+    for (path, content) in files {
+        let path = root.join(path);
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent).context("create project directory")?;
+        }
+        fs::write(&path, substitute(content))
+            .with_context(|| format!("write {}", path.display()))?;
+    }
+    Ok(())
+}
+
+/// Ask the user to pick one of the programming languages supported by the build.
+fn ask_lang() -> Result<Lang> {
+    let langs: Vec<_> = Lang::value_variants()
+        .iter()
+        .filter(|lang| is_supported(lang))
+        .collect();
+    let names: Vec<_> = langs.iter().map(|lang| lang_name(lang)).collect();
+    let index = ask_choice("programming language", &names)?;
+    Ok(langs[index].clone())
+}
+
+/// Ask the user to pick one of the templates available for the language.
+fn ask_template(lang: &Lang) -> Result<&'static Template> {
+    let templates: Vec<_> = TEMPLATES
+        .iter()
+        .filter(|t| supports_lang(t, lang))
+        .collect();
+    if let [template] = templates[..] {
+        return Ok(template);
+    }
+    let names: Vec<_> = templates
+        .iter()
+        .map(|t| format!("{} ({})", t.name, t.description))
+        .collect();
+    let index = ask_choice("template", &names)?;
+    Ok(templates[index])
+}
+
+fn find_template(name: &str, lang: &Lang) -> Result<&'static Template> {
+    let Some(template) = TEMPLATES.iter().find(|t| t.name == name) else {
+        let names: Vec<_> = TEMPLATES.iter().map(|t| t.name).collect();
+        bail!("unknown template {name}, available: {}", names.join(", "));
+    };
+    if !supports_lang(template, lang) {
+        bail!("template {name} is not available for {}", lang_name(lang));
+    }
+    Ok(template)
+}
+
+fn supports_lang(template: &Template, lang: &Lang) -> bool {
+    template.langs.is_empty() || template.langs.contains(lang)
+}
+
+/// Show the numbered list of options and read the number of the chosen one.
+fn ask_choice(question: &str, options: &[String]) -> Result<usize> {
+    println!("👀 pick the {question}:");
+    for (i, option) in options.iter().enumerate() {
+        println!("  {}. {option}", i + 1);
+    }
+    loop {
+        let answer = ask("number", "1")?;
+        match answer.parse::<usize>() {
+            Ok(n) if n >= 1 && n <= options.len() => return Ok(n - 1),
+            _ => println!("⚠️  enter a number from 1 to {}", options.len()),
+        }
+    }
+}
+
+/// Read a line from stdin, using the default if the line is empty.
+fn ask(question: &str, default: &str) -> Result<String> {
+    print!("{question} [{default}]: ");
+    std::io::stdout().flush().context("flush stdout")?;
+    let mut answer = String::new();
+    let stdin = std::io::stdin();
+    stdin.lock().read_line(&mut answer).context("read answer")?;
+    let answer = answer.trim();
+    if answer.is_empty() {
+        return Ok(default.to_string());
+    }
+    Ok(answer.to_string())
+}
+
+fn lang_name(lang: &Lang) -> String {
+    match lang.to_possible_value() {
+        Some(value) => value.get_name().to_string(),
+        None => format!("{lang:?}"),
+    }
+}
+
+/// Make a human-readable name from an ID: "snake-game" becomes "Snake Game".
+pub fn make_name(id: &str) -> String {
+    let words: Vec<_> = id
+        .split('-')
+        .filter(|word| !word.is_empty())
+        .map(|word| {
+            let mut chars = word.chars();
+            match chars.next() {
+                Some(first) => first.to_uppercase().chain(chars).collect(),
+                None => String::new(),
+            }
+        })
+        .collect();
+    words.join(" ")
+}
+
+fn is_non_empty_dir(path: &Path) -> bool {
+    match fs::read_dir(path) {
+        Ok(mut entries) => entries.next().is_some(),
+        Err(_) => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_make_name() {
+        assert_eq!(make_name("snek"), "Snek");
+        assert_eq!(make_name("snake-game"), "Snake Game");
+        assert_eq!(make_name("x--y"), "X Y");
+    }
+
+    #[test]
+    fn test_find_template() {
+        assert_eq!(find_template("minimal", &Lang::Go).unwrap().name, "minimal");
+        assert_eq!(find_template("blank", &Lang::Zig).unwrap().name, "blank");
+        assert!(find_template("minimal", &Lang::Zig).is_err());
+        assert!(find_template("nope", &Lang::Go).is_err());
+    }
+
+    #[test]
+    fn test_scaffold() {
+        let root = make_tmp_dir().join("snake-game");
+        assert!(!is_non_empty_dir(&root));
+        let template = find_template("minimal", &Lang::Rust).unwrap();
+        scaffold(&root, "greg", "snake-game", &Lang::Rust, template).unwrap();
+        assert!(is_non_empty_dir(&root));
+        assert!(root.join("src").join("main.rs").is_file());
+        let cargo = fs::read_to_string(root.join("Cargo.toml")).unwrap();
+        assert!(cargo.contains("name = \"snake-game\""));
+        let config = fs::read_to_string(root.join("firefly.toml")).unwrap();
+        assert!(config.contains("author_id = \"greg\""));
+        assert!(config.contains("app_name = \"Snake Game\""));
+        assert!(config.contains("lang = \"rust\""));
+    }
+}
@@ -0,0 +1,75 @@
+use anyhow::{Context, Result};
+use serde::Serialize;
+use std::fmt::Display;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// If the output should be machine-readable JSON instead of human text.
+static JSON: AtomicBool = AtomicBool::new(false);
+
+/// If the progress reporting should be suppressed.
+static QUIET: AtomicBool = AtomicBool::new(false);
+
+/// Switch all commands supporting it to JSON output.
+pub fn set_json(json: bool) {
+    JSON.store(json, Ordering::Relaxed);
+}
+
+/// Check if the `--json` flag is passed.
+pub fn is_json() -> bool {
+    JSON.load(Ordering::Relaxed)
+}
+
+/// Suppress the progress reporting.
+pub fn set_quiet(quiet: bool) {
+    QUIET.store(quiet, Ordering::Relaxed);
+}
+
+/// Check if the `--quiet` flag is passed.
+pub fn is_quiet() -> bool {
+    QUIET.load(Ordering::Relaxed)
+}
+
+/// Serialize the value as JSON and write it into stdout.
+pub fn print_json<T: Serialize + ?Sized>(value: &T) -> Result<()> {
+    let out = serde_json::to_string_pretty(value).context("serialize JSON")?;
+    println!("{out}");
+    Ok(())
+}
+
+/// An error that is already included into the command output.
+///
+/// The command fails (and exits with a non-zero status code)
+/// but the error doesn't need to be printed once more.
+#[derive(Debug)]
+pub struct Reported(pub String);
+
+impl Display for Reported {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}", self.0)
+    }
+}
+
+impl std::error::Error for Reported {}
+
+#[derive(Serialize)]
+struct ErrorInfo<'a> {
+    error: &'a str,
+}
+
+/// Write the error as a JSON object into stdout.
+pub fn print_json_error(error: &str) {
+    // Serializing a struct with a single string field never fails.
+    _ = print_json(&ErrorInfo { error });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_error_info() {
+        let info = ErrorInfo { error: "oh \"no\"" };
+        let out = serde_json::to_string(&info).unwrap();
+        assert_eq!(out, r#"{"error":"oh \"no\""}"#);
+    }
+}
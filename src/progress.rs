@@ -0,0 +1,114 @@
+use crate::budget::{format_size, size_unit};
+use crate::output::is_quiet;
+use std::io::{IsTerminal, Write};
+use std::sync::atomic::{AtomicU64, Ordering};
+
+/// When the total size is not known, report the progress after every megabyte.
+const STEP_BYTES: u64 = 1024 * 1024;
+
+/// What is being counted by the progress.
+#[derive(Clone, Copy)]
+pub enum Unit {
+    Items,
+    Bytes,
+}
+
+/// Progress reporting for long-running operations.
+///
+/// On a terminal, the progress line is redrawn in place.
+/// Otherwise (CI, pipes), a plain line is printed for every 10% of the progress.
+/// The progress goes into stderr so that it doesn't mix with the command output.
+pub struct Progress {
+    label: &'static str,
+    unit:  Unit,
+    total: Option<u64>,
+    done:  AtomicU64,
+    /// The last reported step, for the non-terminal output.
+    step:  AtomicU64,
+    tty:   bool,
+    quiet: bool,
+}
+
+impl Progress {
+    pub fn new(label: &'static str, unit: Unit, total: Option<u64>) -> Self {
+        Self {
+            label,
+            unit,
+            total,
+            done: AtomicU64::new(0),
+            step: AtomicU64::new(0),
+            tty: std::io::stderr().is_terminal(),
+            quiet: is_quiet(),
+        }
+    }
+
+    /// Advance the progress by the given amount. Can be called from multiple threads.
+    pub fn inc(&self, delta: u64) {
+        let done = self.done.fetch_add(delta, Ordering::Relaxed) + delta;
+        if self.quiet {
+            return;
+        }
+        if self.tty {
+            eprint!("\r{}: {}", self.label, self.format(done));
+            _ = std::io::stderr().flush();
+            return;
+        }
+        let step = match self.total {
+            Some(total) if total > 0 => done * 10 / total,
+            _ => done / STEP_BYTES,
+        };
+        if self.step.fetch_max(step, Ordering::Relaxed) < step {
+            eprintln!("{}: {}", self.label, self.format(done));
+        }
+    }
+
+    /// Finish the progress line.
+    pub fn finish(&self) {
+        if self.quiet {
+            return;
+        }
+        if self.tty {
+            eprintln!();
+            return;
+        }
+        let done = self.done.load(Ordering::Relaxed);
+        // If the total is known, the last line is printed on reaching 100%.
+        if self.total.is_none() {
+            eprintln!("{}: {}", self.label, self.format(done));
+        }
+    }
+
+    fn format(&self, done: u64) -> String {
+        match (self.unit, self.total) {
+            (Unit::Items, Some(total)) => format!("{done} of {total}"),
+            (Unit::Items, None) => format!("{done}"),
+            (Unit::Bytes, Some(total)) if total > 0 => {
+                let percent = done * 100 / total;
+                let (unit_size, _) = size_unit(total);
+                format!(
+                    "{percent:>3}% ({}/{})",
+                    done / unit_size,
+                    format_size(total)
+                )
+            }
+            (Unit::Bytes, _) => format_size(done),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format() {
+        let progress = Progress::new("converting files", Unit::Items, Some(12));
+        assert_eq!(progress.format(3), "3 of 12");
+        let progress = Progress::new("downloading", Unit::Bytes, Some(4096));
+        assert_eq!(progress.format(1024), " 25% (1/4 Kb)");
+        let progress = Progress::new("downloading", Unit::Bytes, None);
+        assert_eq!(progress.format(3072), "3 Kb");
+        let progress = Progress::new("downloading", Unit::Bytes, Some(3 * 1024 * 1024 + 1));
+        assert_eq!(progress.format(1024 * 1024), " 33% (1/3 Mb)");
+    }
+}
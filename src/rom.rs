@@ -0,0 +1,65 @@
+//! Access the ROM files of installed apps and exported archives.
+
+use crate::vfs::parse_app_id;
+use anyhow::{bail, Context, Result};
+use std::env::temp_dir;
+use std::fs::{self, File};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use zip::ZipArchive;
+
+/// A temporary directory that is removed when dropped.
+pub struct TempDir {
+    pub path: PathBuf,
+}
+
+impl Drop for TempDir {
+    fn drop(&mut self) {
+        _ = fs::remove_dir_all(&self.path);
+    }
+}
+
+/// The directory with the ROM files.
+pub struct RomDir {
+    pub path: PathBuf,
+    /// If the ROM was extracted from an archive, the directory is removed when dropped.
+    _tmp_dir: Option<TempDir>,
+}
+
+/// Find the installed app by its ID or extract the ROM archive by its path.
+pub fn open_rom(vfs: &Path, target: &str) -> Result<RomDir> {
+    let path = Path::new(target);
+    if path.is_file() {
+        let tmp_dir = extract_archive(path).context("extract archive")?;
+        return Ok(RomDir {
+            path:     tmp_dir.path.clone(),
+            _tmp_dir: Some(tmp_dir),
+        });
+    }
+    let (author_id, app_id) = parse_app_id(target)?;
+    let rom_path = vfs.join("roms").join(author_id).join(app_id);
+    if !rom_path.is_dir() {
+        bail!("the app {target} is not installed and there is no such file");
+    }
+    Ok(RomDir {
+        path:     rom_path,
+        _tmp_dir: None,
+    })
+}
+
+/// Extract the ROM archive into a new temporary directory.
+pub fn extract_archive(path: &Path) -> Result<TempDir> {
+    static COUNTER: AtomicUsize = AtomicUsize::new(0);
+    let file = File::open(path).context("open archive file")?;
+    let mut archive = ZipArchive::new(file).context("open archive")?;
+    let pid = std::process::id();
+    // Multiple archives might be extracted at the same time.
+    let n = COUNTER.fetch_add(1, Ordering::Relaxed);
+    let tmp_dir = TempDir {
+        path: temp_dir().join(format!("firefly-rom-{pid}-{n}")),
+    };
+    _ = fs::remove_dir_all(&tmp_dir.path);
+    fs::create_dir_all(&tmp_dir.path).context("create temp dir")?;
+    archive.extract(&tmp_dir.path).context("extract files")?;
+    Ok(tmp_dir)
+}
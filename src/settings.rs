@@ -0,0 +1,136 @@
+use crate::args::{ConfigGetArgs, ConfigSetArgs};
+use crate::output::{is_json, print_json};
+use anyhow::{bail, Context, Result};
+use directories::ProjectDirs;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// All settings that can be changed with `config set`.
+const SETTINGS_KEYS: &[&str] = &["author"];
+
+/// User-level settings shared by all projects.
+#[derive(Serialize, Deserialize, Default, Debug, PartialEq, Eq)]
+pub struct Settings {
+    /// The default author ID for new projects and projects without `author_id`.
+    pub author: Option<String>,
+}
+
+impl Settings {
+    /// Read the settings file. If it doesn't exist, the default settings are used.
+    pub fn load() -> Result<Self> {
+        Self::load_from(&settings_path())
+    }
+
+    fn load_from(path: &Path) -> Result<Self> {
+        if !path.exists() {
+            return Ok(Self::default());
+        }
+        let raw = fs::read_to_string(path).context("read settings file")?;
+        let settings = toml::from_str(&raw).context("parse settings file")?;
+        Ok(settings)
+    }
+
+    fn save_to(&self, path: &Path) -> Result<()> {
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent).context("create settings directory")?;
+        }
+        let raw = toml::to_string(self).context("serialize settings")?;
+        fs::write(path, raw).context("write settings file")?;
+        Ok(())
+    }
+
+    fn get(&self, key: &str) -> Result<Option<&str>> {
+        match key {
+            "author" => Ok(self.author.as_deref()),
+            _ => bail!("unknown setting: {key}"),
+        }
+    }
+
+    fn set(&mut self, key: &str, value: &str) -> Result<()> {
+        match key {
+            "author" => {
+                if let Err(err) = firefly_meta::validate_id(value) {
+                    bail!("invalid author ID: {err}");
+                }
+                self.author = Some(value.to_string());
+            }
+            _ => bail!("unknown setting: {key}"),
+        }
+        Ok(())
+    }
+}
+
+/// The path to the user-level settings file.
+///
+/// It's the preferences dir and not the config dir because on macOS
+/// the latter is the same as the data dir, which is used as the VFS root.
+fn settings_path() -> PathBuf {
+    match ProjectDirs::from("com", "firefly", "firefly") {
+        Some(dirs) => dirs.preference_dir().join("settings.toml"),
+        None => PathBuf::from(".firefly-settings.toml"),
+    }
+}
+
+pub fn cmd_config_set(args: &ConfigSetArgs) -> Result<()> {
+    let path = settings_path();
+    let mut settings = Settings::load_from(&path)?;
+    settings.set(&args.key, &args.value)?;
+    settings.save_to(&path)?;
+    println!("✅ {} is set to {}", args.key, args.value);
+    Ok(())
+}
+
+pub fn cmd_config_get(args: &ConfigGetArgs) -> Result<()> {
+    let settings = Settings::load()?;
+    let value = settings.get(&args.key)?;
+    if is_json() {
+        return print_json(&value);
+    }
+    match value {
+        Some(value) => println!("{value}"),
+        None => bail!("{} is not set", args.key),
+    }
+    Ok(())
+}
+
+pub fn cmd_config_list() -> Result<()> {
+    let settings = Settings::load()?;
+    if is_json() {
+        return print_json(&settings);
+    }
+    for key in SETTINGS_KEYS {
+        match settings.get(key)? {
+            Some(value) => println!("{key} = {value}"),
+            None => println!("{key} is not set"),
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_save_load() {
+        let path = make_tmp_dir().join("settings.toml");
+        let settings = Settings::load_from(&path).unwrap();
+        assert_eq!(settings, Settings::default());
+
+        let mut settings = Settings::default();
+        settings.set("author", "greg").unwrap();
+        settings.save_to(&path).unwrap();
+        let settings = Settings::load_from(&path).unwrap();
+        assert_eq!(settings.get("author").unwrap(), Some("greg"));
+    }
+
+    #[test]
+    fn test_set_invalid() {
+        let mut settings = Settings::default();
+        assert!(settings.set("author", "Greg!").is_err());
+        assert!(settings.set("color", "red").is_err());
+        assert_eq!(settings.author, None);
+    }
+}
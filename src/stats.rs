@@ -0,0 +1,216 @@
+use crate::binary::{write_len, write_str, Reader};
+use crate::config::{BadgeConfig, BoardConfig, Config, Direction};
+use crate::file_names::{BADGES, BOARDS};
+use anyhow::{bail, Context, Result};
+use std::fs;
+
+const MAX_NAME_LEN: usize = 64;
+const MAX_DESCRIPTION_LEN: usize = 256;
+
+/// Validate the badges from the config and write them into the ROM.
+pub fn write_badges(config: &Config, badges: &[BadgeConfig]) -> Result<()> {
+    validate_badges(badges)?;
+    let raw = encode_badges(badges)?;
+    let output_path = config.rom_path.join(BADGES);
+    fs::write(output_path, raw).context("write badges file")?;
+    Ok(())
+}
+
+fn validate_badges(badges: &[BadgeConfig]) -> Result<()> {
+    let ids: Vec<_> = badges.iter().map(|b| b.id.as_str()).collect();
+    validate_ids("badge", &ids)?;
+    for badge in badges {
+        if badge.name.is_empty() {
+            bail!("badge {}: name must not be empty", badge.id);
+        }
+        if badge.name.len() > MAX_NAME_LEN {
+            bail!(
+                "badge {}: name is longer than {MAX_NAME_LEN} bytes",
+                badge.id
+            );
+        }
+        if badge.description.len() > MAX_DESCRIPTION_LEN {
+            bail!(
+                "badge {}: description is longer than {MAX_DESCRIPTION_LEN} bytes",
+                badge.id
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Check that all IDs are valid and unique.
+fn validate_ids(kind: &str, ids: &[&str]) -> Result<()> {
+    for (i, id) in ids.iter().enumerate() {
+        if let Err(err) = firefly_meta::validate_id(id) {
+            bail!("{kind} #{}: invalid ID {id:?}: {err}", i + 1);
+        }
+        if let Some(prev) = ids[..i].iter().position(|other| other == id) {
+            bail!(
+                "{kind} #{}: duplicate ID {id:?}, already used by {kind} #{}",
+                i + 1,
+                prev + 1
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Serialize the badges.
+///
+/// The format is the number of badges (u16) followed by the badges.
+/// Each badge is the ID, the name, and the description (each prefixed
+/// by its length as u16), and then the hidden flag (u8).
+/// All numbers are little-endian.
+fn encode_badges(badges: &[BadgeConfig]) -> Result<Vec<u8>> {
+    let mut raw = Vec::new();
+    write_len(&mut raw, badges.len())?;
+    for badge in badges {
+        write_str(&mut raw, &badge.id)?;
+        write_str(&mut raw, &badge.name)?;
+        write_str(&mut raw, &badge.description)?;
+        raw.push(u8::from(badge.hidden));
+    }
+    Ok(raw)
+}
+
+/// Parse the badges file written by [`write_badges`].
+pub fn decode_badges(raw: &[u8]) -> Result<Vec<BadgeConfig>> {
+    let mut reader = Reader::new(raw);
+    let count = reader.read_u16()?;
+    let mut badges = Vec::new();
+    for _ in 0..count {
+        badges.push(BadgeConfig {
+            id:          reader.read_str()?,
+            name:        reader.read_str()?,
+            description: reader.read_str()?,
+            hidden:      reader.read_u8()? != 0,
+        });
+    }
+    Ok(badges)
+}
+
+/// Validate the scoreboards from the config and write them into the ROM.
+pub fn write_boards(config: &Config, boards: &[BoardConfig]) -> Result<()> {
+    validate_boards(boards)?;
+    let raw = encode_boards(boards)?;
+    let output_path = config.rom_path.join(BOARDS);
+    fs::write(output_path, raw).context("write boards file")?;
+    Ok(())
+}
+
+fn validate_boards(boards: &[BoardConfig]) -> Result<()> {
+    let ids: Vec<_> = boards.iter().map(|b| b.id.as_str()).collect();
+    validate_ids("board", &ids)?;
+    for board in boards {
+        if board.title.is_empty() {
+            bail!("board {}: title must not be empty", board.id);
+        }
+        if board.title.len() > MAX_NAME_LEN {
+            bail!(
+                "board {}: title is longer than {MAX_NAME_LEN} bytes",
+                board.id
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Serialize the scoreboards.
+///
+/// The format is the number of boards (u16) followed by the boards.
+/// Each board is the ID and the title (each prefixed by its length as u16),
+/// and then the direction (u8): 0 if higher is better, 1 if lower is better.
+/// All numbers are little-endian.
+fn encode_boards(boards: &[BoardConfig]) -> Result<Vec<u8>> {
+    let mut raw = Vec::new();
+    write_len(&mut raw, boards.len())?;
+    for board in boards {
+        write_str(&mut raw, &board.id)?;
+        write_str(&mut raw, &board.title)?;
+        raw.push(match board.direction {
+            Direction::HigherIsBetter => 0,
+            Direction::LowerIsBetter => 1,
+        });
+    }
+    Ok(raw)
+}
+
+/// Parse the boards file written by [`write_boards`].
+pub fn decode_boards(raw: &[u8]) -> Result<Vec<BoardConfig>> {
+    let mut reader = Reader::new(raw);
+    let count = reader.read_u16()?;
+    let mut boards = Vec::new();
+    for _ in 0..count {
+        let id = reader.read_str()?;
+        let title = reader.read_str()?;
+        let direction = match reader.read_u8()? {
+            0 => Direction::HigherIsBetter,
+            1 => Direction::LowerIsBetter,
+            n => bail!("invalid direction: {n}"),
+        };
+        boards.push(BoardConfig {
+            id,
+            title,
+            direction,
+        });
+    }
+    Ok(boards)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn make_badge(id: &str) -> BadgeConfig {
+        BadgeConfig {
+            id:          id.to_string(),
+            name:        "Some Badge".to_string(),
+            description: "earn it".to_string(),
+            hidden:      false,
+        }
+    }
+
+    #[test]
+    fn test_validate_badges() {
+        validate_badges(&[make_badge("first"), make_badge("second")]).unwrap();
+        let err = validate_badges(&[make_badge("a"), make_badge("b"), make_badge("a")]);
+        assert_eq!(
+            err.unwrap_err().to_string(),
+            "badge #3: duplicate ID \"a\", already used by badge #1"
+        );
+        assert!(validate_badges(&[make_badge("Not Valid")]).is_err());
+        let mut badge = make_badge("long");
+        badge.name = "x".repeat(MAX_NAME_LEN + 1);
+        assert!(validate_badges(&[badge]).is_err());
+    }
+
+    #[test]
+    fn test_validate_boards() {
+        let board = |id: &str| BoardConfig {
+            id:        id.to_string(),
+            title:     "Best Time".to_string(),
+            direction: Direction::LowerIsBetter,
+        };
+        validate_boards(&[board("time"), board("score")]).unwrap();
+        let err = validate_boards(&[board("time"), board("time")]).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "board #2: duplicate ID \"time\", already used by board #1"
+        );
+        let raw = encode_boards(&[board("time"), board("score")]).unwrap();
+        let decoded = decode_boards(&raw).unwrap();
+        assert_eq!(decoded, vec![board("time"), board("score")]);
+    }
+
+    #[test]
+    fn test_encode_decode_badges() {
+        let mut hidden = make_badge("secret");
+        hidden.hidden = true;
+        let badges = vec![make_badge("first"), hidden];
+        let raw = encode_badges(&badges).unwrap();
+        let decoded = decode_badges(&raw).unwrap();
+        assert_eq!(decoded, badges);
+        assert!(decode_badges(&raw[..raw.len() - 1]).is_err());
+    }
+}
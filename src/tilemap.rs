@@ -0,0 +1,364 @@
+//! Convert tilemaps (levels) made in Tiled into a compact binary format.
+//!
+//! The output file starts with the map width (u16), height (u16),
+//! and the size of a single cell in bytes (u8, 1 or 2).
+//! Then go the cells, row by row, little-endian.
+//! A cell is 0 if it's empty and the tile index plus one otherwise.
+
+use crate::config::FileConfig;
+use anyhow::{bail, Context, Result};
+use std::fmt::Write as _;
+use std::fs;
+use std::path::Path;
+
+/// How many out-of-range tiles to list in the error message.
+const MAX_REPORTED: usize = 8;
+
+/// The bits of the Tiled tile ID used for flipping and rotation.
+const GID_FLAGS: u32 = 0xf000_0000;
+
+/// The max number of cells in a tilemap.
+///
+/// Protects from allocating huge buffers for a malformed map.
+/// Bigger maps wouldn't fit into the device memory anyway.
+const MAX_CELLS: usize = 1 << 20;
+
+#[derive(Debug, PartialEq, Eq)]
+struct Tilemap {
+    width:  u16,
+    height: u16,
+    /// Tile indices, row by row. `None` for empty cells.
+    cells:  Vec<Option<u32>>,
+}
+
+pub fn convert_tilemap(
+    input_path: &Path,
+    output_path: &Path,
+    file_config: &FileConfig,
+) -> Result<()> {
+    let Some(tiles) = file_config.tiles else {
+        bail!("the number of tiles in the tileset must be specified as `tiles`");
+    };
+    let raw = fs::read_to_string(input_path).context("read tilemap")?;
+    let is_tmx = input_path.extension().is_some_and(|ext| ext == "tmx");
+    let tilemap = if is_tmx {
+        parse_tmx(&raw, file_config.layer.as_deref())?
+    } else {
+        parse_csv(&raw)?
+    };
+    check_tiles(&tilemap, tiles)?;
+    let raw = encode_tilemap(&tilemap, tiles);
+    fs::write(output_path, raw).context("write tilemap")?;
+    Ok(())
+}
+
+/// Parse a CSV grid exported from Tiled. Empty cells are -1.
+fn parse_csv(raw: &str) -> Result<Tilemap> {
+    let mut cells = Vec::new();
+    let mut width = None;
+    let mut height: usize = 0;
+    for line in raw.lines() {
+        let line = line.trim().trim_end_matches(',');
+        if line.is_empty() {
+            continue;
+        }
+        let line_cells = line
+            .split(',')
+            .map(|cell| parse_cell(cell.trim()))
+            .collect::<Result<Vec<_>>>()
+            .with_context(|| format!("parse row {height}"))?;
+        match width {
+            None => width = Some(line_cells.len()),
+            Some(width) if width != line_cells.len() => {
+                bail!(
+                    "row {height} has {} cells, expected {width}",
+                    line_cells.len()
+                );
+            }
+            Some(_) => {}
+        }
+        cells.extend(line_cells);
+        height += 1;
+    }
+    make_tilemap(width.unwrap_or(0), height, cells)
+}
+
+fn parse_cell(cell: &str) -> Result<Option<u32>> {
+    if cell == "-1" {
+        return Ok(None);
+    }
+    let index = cell
+        .parse()
+        .with_context(|| format!("invalid tile index: {cell}"))?;
+    Ok(Some(index))
+}
+
+/// Parse a TMX file saved by Tiled with the CSV layer format.
+fn parse_tmx(raw: &str, layer_name: Option<&str>) -> Result<Tilemap> {
+    let Some(map) = find_tag(raw, "map") else {
+        bail!("the map element not found");
+    };
+    let width: usize = parse_attr(map, "width")?;
+    let height: usize = parse_attr(map, "height")?;
+    let size = match width.checked_mul(height) {
+        Some(size) if size <= MAX_CELLS => size,
+        _ => bail!("the map is too big: {width}x{height}"),
+    };
+    let tilesets = raw
+        .split("<tileset")
+        .skip(1)
+        .filter(|rest| rest.starts_with(|c: char| c.is_whitespace() || c == '>' || c == '/'))
+        .count();
+    if tilesets > 1 {
+        bail!("the map has {tilesets} tilesets, only one is supported");
+    }
+    let first_gid: u32 = match find_tag(raw, "tileset") {
+        Some(tileset) => parse_attr(tileset, "firstgid")?,
+        None => 1,
+    };
+
+    let mut layers = raw.split("<layer").skip(1);
+    let layer = if let Some(name) = layer_name {
+        layers.find(|layer| get_attr(layer, "name") == Some(name))
+    } else {
+        let layer = layers.next();
+        if layers.next().is_some() {
+            bail!("the map has multiple layers, specify which one to use as `layer`");
+        }
+        layer
+    };
+    let Some(layer) = layer else {
+        bail!("tile layer not found");
+    };
+    let Some(data) = find_tag(layer, "data") else {
+        bail!("the layer has no data");
+    };
+    if get_attr(data, "encoding") != Some("csv") {
+        bail!("only CSV layer format is supported, change it in the map properties in Tiled");
+    }
+    let Some(start) = layer.find("<data") else {
+        bail!("the layer has no data");
+    };
+    let Some((_, content)) = layer[start..].split_once('>') else {
+        bail!("the layer data is not closed");
+    };
+    let Some((content, _)) = content.split_once("</data>") else {
+        bail!("the layer data is not closed");
+    };
+
+    let mut cells = Vec::with_capacity(size);
+    for (i, cell) in content.split(',').enumerate() {
+        let cell = cell.trim();
+        let gid: u32 = cell
+            .parse()
+            .with_context(|| format!("invalid tile ID: {cell}"))?;
+        if gid == 0 {
+            cells.push(None);
+            continue;
+        }
+        let (x, y) = (i % width.max(1), i / width.max(1));
+        if gid & GID_FLAGS != 0 {
+            bail!("the tile at ({x}, {y}) is flipped or rotated, it's not supported");
+        }
+        let Some(index) = gid.checked_sub(first_gid) else {
+            bail!("the tile at ({x}, {y}) has ID {gid} below the tileset firstgid {first_gid}");
+        };
+        cells.push(Some(index));
+    }
+    if cells.len() != size {
+        bail!(
+            "the layer has {} tiles, expected {width}x{height}",
+            cells.len()
+        );
+    }
+    make_tilemap(width, height, cells)
+}
+
+fn make_tilemap(width: usize, height: usize, cells: Vec<Option<u32>>) -> Result<Tilemap> {
+    if width == 0 || height == 0 {
+        bail!("the tilemap is empty");
+    }
+    let (Ok(width), Ok(height)) = (u16::try_from(width), u16::try_from(height)) else {
+        bail!("the tilemap is too big");
+    };
+    Ok(Tilemap {
+        width,
+        height,
+        cells,
+    })
+}
+
+/// Find the first element with the given name and return its attributes.
+fn find_tag<'a>(raw: &'a str, name: &str) -> Option<&'a str> {
+    let open = format!("<{name}");
+    let mut rest = raw;
+    loop {
+        let start = rest.find(&open)? + open.len();
+        rest = &rest[start..];
+        // Make sure it's not just a tag starting with the same name.
+        if rest.starts_with(|c: char| c.is_whitespace() || c == '>' || c == '/') {
+            let end = rest.find('>')?;
+            return Some(&rest[..end]);
+        }
+    }
+}
+
+/// Get the value of the attribute from the element attributes.
+fn get_attr<'a>(attrs: &'a str, name: &str) -> Option<&'a str> {
+    let attrs = &attrs[..attrs.find('>').unwrap_or(attrs.len())];
+    let pattern = format!(" {name}=\"");
+    let start = attrs.find(&pattern)? + pattern.len();
+    let len = attrs[start..].find('"')?;
+    Some(&attrs[start..start + len])
+}
+
+fn parse_attr<T: std::str::FromStr>(attrs: &str, name: &str) -> Result<T> {
+    let Some(value) = get_attr(attrs, name) else {
+        bail!("the attribute {name} not found");
+    };
+    let Ok(value) = value.parse() else {
+        bail!("invalid value for {name}: {value}");
+    };
+    Ok(value)
+}
+
+/// Make sure that all tile indices fit into the tileset.
+fn check_tiles(tilemap: &Tilemap, tiles: u16) -> Result<()> {
+    let width = usize::from(tilemap.width);
+    let bad: Vec<_> = tilemap
+        .cells
+        .iter()
+        .enumerate()
+        .filter_map(|(i, cell)| match cell {
+            Some(index) if *index >= u32::from(tiles) => Some((i % width, i / width, *index)),
+            _ => None,
+        })
+        .collect();
+    if bad.is_empty() {
+        return Ok(());
+    }
+    let mut msg = format!(
+        "{} tile(s) out of range (the tileset has {tiles}):",
+        bad.len()
+    );
+    for (x, y, index) in bad.iter().take(MAX_REPORTED) {
+        _ = write!(msg, " {index} at ({x}, {y})");
+    }
+    if bad.len() > MAX_REPORTED {
+        msg.push_str(" ...");
+    }
+    bail!(msg)
+}
+
+fn encode_tilemap(tilemap: &Tilemap, tiles: u16) -> Vec<u8> {
+    // The cell also needs to fit "empty" (zero).
+    let cell_size: u8 = if tiles < 256 { 1 } else { 2 };
+    let mut raw = Vec::with_capacity(5 + tilemap.cells.len() * usize::from(cell_size));
+    raw.extend_from_slice(&tilemap.width.to_le_bytes());
+    raw.extend_from_slice(&tilemap.height.to_le_bytes());
+    raw.push(cell_size);
+    for cell in &tilemap.cells {
+        // Tile indices are already checked to fit into the tileset.
+        #[allow(clippy::cast_possible_truncation)]
+        let value = cell.map_or(0, |index| index as u16 + 1);
+        if cell_size == 1 {
+            #[allow(clippy::cast_possible_truncation)]
+            raw.push(value as u8);
+        } else {
+            raw.extend_from_slice(&value.to_le_bytes());
+        }
+    }
+    raw
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const TMX: &str = r#"<?xml version="1.0" encoding="UTF-8"?>
+<map version="1.10" orientation="orthogonal" width="3" height="2" tilewidth="8" tileheight="8">
+ <tileset firstgid="1" source="tiles.tsx"/>
+ <layer id="1" name="ground" width="3" height="2">
+  <data encoding="csv">
+1,2,0,
+0,3,4
+</data>
+ </layer>
+ <layer id="2" name="decor" width="3" height="2">
+  <data encoding="csv">
+0,0,0,
+0,0,5
+</data>
+ </layer>
+</map>
+"#;
+
+    #[test]
+    fn test_parse_csv() {
+        let tilemap = parse_csv("0,1,-1\n2,-1,3\n").unwrap();
+        assert_eq!(tilemap.width, 3);
+        assert_eq!(tilemap.height, 2);
+        assert_eq!(
+            tilemap.cells,
+            vec![Some(0), Some(1), None, Some(2), None, Some(3)]
+        );
+        assert!(parse_csv("0,1\n2\n").is_err());
+        assert!(parse_csv("").is_err());
+        assert!(parse_csv("0,x\n").is_err());
+    }
+
+    #[test]
+    fn test_parse_tmx() {
+        assert!(parse_tmx(TMX, None).is_err());
+        let tilemap = parse_tmx(TMX, Some("ground")).unwrap();
+        assert_eq!(tilemap.width, 3);
+        assert_eq!(tilemap.height, 2);
+        assert_eq!(
+            tilemap.cells,
+            vec![Some(0), Some(1), None, None, Some(2), Some(3)]
+        );
+        let tilemap = parse_tmx(TMX, Some("decor")).unwrap();
+        assert_eq!(tilemap.cells[5], Some(4));
+        assert!(parse_tmx(TMX, Some("sky")).is_err());
+        let raw = TMX.replace("encoding=\"csv\"", "encoding=\"base64\"");
+        assert!(parse_tmx(&raw, Some("ground")).is_err());
+
+        let raw = TMX.replace("firstgid=\"1\"", "firstgid=\"2\"");
+        assert!(
+            parse_tmx(&raw, Some("ground")).is_err(),
+            "gid below firstgid"
+        );
+        let tileset = " <tileset firstgid=\"1\" source=\"tiles.tsx\"/>\n";
+        let raw = TMX.replace(tileset, &format!("{tileset}{tileset}"));
+        assert!(
+            parse_tmx(&raw, Some("ground")).is_err(),
+            "multiple tilesets"
+        );
+        let raw = TMX.replace(
+            "width=\"3\" height=\"2\" tilewidth",
+            "width=\"99999999999\" height=\"99999999999\" tilewidth",
+        );
+        assert!(parse_tmx(&raw, Some("ground")).is_err(), "too big");
+    }
+
+    #[test]
+    fn test_check_tiles() {
+        let tilemap = parse_csv("0,1,-1\n2,-1,3\n").unwrap();
+        check_tiles(&tilemap, 4).unwrap();
+        let err = check_tiles(&tilemap, 3).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "1 tile(s) out of range (the tileset has 3): 3 at (2, 1)"
+        );
+    }
+
+    #[test]
+    fn test_encode_tilemap() {
+        let tilemap = parse_csv("0,-1\n1,2\n").unwrap();
+        let raw = encode_tilemap(&tilemap, 3);
+        assert_eq!(raw, vec![2, 0, 2, 0, 1, 1, 0, 2, 3]);
+        let raw = encode_tilemap(&tilemap, 300);
+        assert_eq!(raw[4], 2);
+        assert_eq!(&raw[5..7], &[1, 0]);
+    }
+}
@@ -0,0 +1,115 @@
+use crate::args::UninstallArgs;
+use crate::vfs::parse_app_id;
+use anyhow::{bail, Context, Result};
+use firefly_meta::ShortMeta;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+pub fn cmd_uninstall(vfs: &Path, args: &UninstallArgs) -> Result<()> {
+    let (author_id, app_id) = parse_app_id(&args.id)?;
+    let targets = find_app_files(vfs, &author_id, &app_id);
+    if targets.is_empty() {
+        bail!("the app {} is not installed", args.id);
+    }
+    for path in &targets {
+        let display = path.strip_prefix(vfs).unwrap_or(path).display();
+        if args.dry_run {
+            println!("👀 would remove {display}");
+            continue;
+        }
+        if path.is_dir() {
+            fs::remove_dir_all(path).with_context(|| format!("remove {display}"))?;
+        } else {
+            fs::remove_file(path).with_context(|| format!("remove {display}"))?;
+        }
+        println!("🗑️  removed {display}");
+    }
+    if args.dry_run {
+        return Ok(());
+    }
+    // If it was the last app of the author, remove the author directories too.
+    for dir in ["roms", "data"] {
+        let author_path = vfs.join(dir).join(&author_id);
+        if is_empty_dir(&author_path) {
+            fs::remove_dir(&author_path).context("remove empty author directory")?;
+        }
+    }
+    println!("✅ uninstalled {}", args.id);
+    Ok(())
+}
+
+/// Find all files and directories in VFS belonging to the app.
+///
+/// That's the ROM, the app data (including saves), and the system files
+/// that refer to the app.
+fn find_app_files(vfs: &Path, author_id: &str, app_id: &str) -> Vec<PathBuf> {
+    let mut targets = Vec::new();
+    for dir in ["roms", "data"] {
+        let path = vfs.join(dir).join(author_id).join(app_id);
+        if path.exists() {
+            targets.push(path);
+        }
+    }
+    for name in ["new-app", "launcher"] {
+        let path = vfs.join("sys").join(name);
+        if refers_to_app(&path, author_id, app_id) {
+            targets.push(path);
+        }
+    }
+    targets
+}
+
+/// Check if the file with the short meta points to the given app.
+fn refers_to_app(path: &Path, author_id: &str, app_id: &str) -> bool {
+    let Ok(raw) = fs::read(path) else {
+        return false;
+    };
+    let Ok(meta) = ShortMeta::decode(&raw) else {
+        return false;
+    };
+    meta.author_id == author_id && meta.app_id == app_id
+}
+
+fn is_empty_dir(path: &Path) -> bool {
+    match fs::read_dir(path) {
+        Ok(mut entries) => entries.next().is_none(),
+        Err(_) => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_cmd_uninstall() {
+        let vfs = make_tmp_vfs();
+        let rom_path = vfs.join("roms").join("greg").join("snek");
+        let data_path = vfs.join("data").join("greg").join("snek");
+        fs::create_dir_all(&rom_path).unwrap();
+        fs::create_dir_all(data_path.join("etc")).unwrap();
+        fs::write(rom_path.join("_bin"), "").unwrap();
+        let other_path = vfs.join("roms").join("greg").join("chess");
+        fs::create_dir_all(&other_path).unwrap();
+
+        let mut args = UninstallArgs {
+            id:      "greg.snek".to_string(),
+            dry_run: true,
+        };
+        cmd_uninstall(&vfs, &args).unwrap();
+        assert!(rom_path.exists());
+        assert!(data_path.exists());
+
+        args.dry_run = false;
+        cmd_uninstall(&vfs, &args).unwrap();
+        assert!(!rom_path.exists());
+        assert!(!data_path.exists());
+        assert!(other_path.exists());
+        // the data dir of the author is empty, the ROMs dir is not
+        assert!(!vfs.join("data").join("greg").exists());
+        assert!(vfs.join("roms").join("greg").exists());
+
+        assert!(cmd_uninstall(&vfs, &args).is_err());
+    }
+}
@@ -0,0 +1,265 @@
+use crate::args::VerifyArgs;
+use crate::crypto::{fingerprint, hash_dir};
+use crate::file_names::{BIN, HASH, KEY, META, SIG};
+use crate::output::{is_json, print_json, Reported};
+use crate::rom::extract_archive;
+use crate::vfs::{list_apps, parse_app_id};
+use anyhow::{bail, Context, Result};
+use data_encoding::HEXLOWER;
+use rsa::pkcs1::DecodeRsaPublicKey;
+use rsa::pkcs1v15::{Signature, VerifyingKey};
+use rsa::signature::hazmat::PrehashVerifier;
+use rsa::RsaPublicKey;
+use serde::Serialize;
+use sha2::Sha256;
+use std::fs;
+use std::path::Path;
+
+/// The files that must be present in every ROM.
+const REQUIRED_FILES: &[&str] = &[META, BIN, HASH];
+
+#[derive(Serialize, Debug, PartialEq, Eq, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum SignatureStatus {
+    Valid,
+    Invalid,
+    Missing,
+}
+
+/// The verification result for a single app, for the JSON output.
+#[derive(Serialize)]
+struct VerifyInfo {
+    id:    String,
+    ok:    bool,
+    key:   Option<String>,
+    error: Option<String>,
+}
+
+pub fn cmd_verify(vfs: &Path, args: &VerifyArgs) -> Result<()> {
+    let key = match &args.key {
+        Some(key) => Some(load_key(vfs, key)?),
+        None => None,
+    };
+    // The archive is extracted into a temporary directory
+    // that is removed when `tmp_dir` goes out of scope.
+    let mut tmp_dir = None;
+    let roms = match &args.id {
+        Some(id) if Path::new(id).is_file() => {
+            let dir = extract_archive(Path::new(id)).context("extract archive")?;
+            let roms = vec![(id.clone(), dir.path.clone())];
+            tmp_dir = Some(dir);
+            roms
+        }
+        Some(id) => {
+            let (author_id, app_id) = parse_app_id(id)?;
+            vec![(id.clone(), vfs.join("roms").join(author_id).join(app_id))]
+        }
+        None => list_apps(vfs)?
+            .into_iter()
+            .map(|(author_id, app_id)| {
+                let rom_path = vfs.join("roms").join(&author_id).join(&app_id);
+                (format!("{author_id}.{app_id}"), rom_path)
+            })
+            .collect(),
+    };
+    if roms.is_empty() && !is_json() {
+        println!("⚠️  no apps installed");
+        return Ok(());
+    }
+    let mut results = Vec::new();
+    for (name, rom_path) in &roms {
+        let result = verify_hash(rom_path).and_then(|()| match &key {
+            Some(key_raw) => check_signature(rom_path, key_raw),
+            None => Ok(()),
+        });
+        let fp = key.as_deref().map(fingerprint);
+        if !is_json() {
+            match (&result, &fp) {
+                (Ok(()), Some(fp)) => println!("✅ {name}: OK, signed by the key {fp}"),
+                (Ok(()), None) => println!("✅ {name}: OK"),
+                (Err(err), _) => println!("💥 {name}: {err:#}"),
+            }
+        }
+        results.push(VerifyInfo {
+            id:    name.clone(),
+            ok:    result.is_ok(),
+            key:   fp,
+            error: result.err().map(|err| format!("{err:#}")),
+        });
+    }
+    drop(tmp_dir);
+    let failed = results.iter().filter(|info| !info.ok).count();
+    if is_json() {
+        print_json(&results)?;
+    }
+    if failed > 0 {
+        let msg = format!("{failed} out of {} app(s) failed verification", roms.len());
+        if is_json() {
+            return Err(Reported(msg).into());
+        }
+        bail!(msg);
+    }
+    Ok(())
+}
+
+/// Read the public key from the given file or from VFS for the given author ID.
+fn load_key(vfs: &Path, key: &str) -> Result<Vec<u8>> {
+    let key_path = Path::new(key);
+    let key_path = if key_path.is_file() {
+        key_path.to_path_buf()
+    } else if firefly_meta::validate_id(key).is_ok() {
+        vfs.join("sys").join("pub").join(key)
+    } else {
+        bail!("the key file not found");
+    };
+    let key_raw = fs::read(key_path).context("read public key")?;
+    RsaPublicKey::from_pkcs1_der(&key_raw).context("decode public key")?;
+    Ok(key_raw)
+}
+
+/// Check that all required files are present and the SHA256 hash matches the files.
+///
+/// There is only one hash for all files, so it cannot tell which file is changed.
+pub fn verify_hash(rom_path: &Path) -> Result<()> {
+    if !rom_path.is_dir() {
+        bail!("the app is not installed");
+    }
+    let missing: Vec<_> = REQUIRED_FILES
+        .iter()
+        .filter(|name| !rom_path.join(name).is_file())
+        .copied()
+        .collect();
+    if !missing.is_empty() {
+        bail!("missing files: {}", missing.join(", "));
+    }
+    let hash_path = rom_path.join(HASH);
+    let hash_expected: &[u8] = &fs::read(hash_path).context("read hash file")?;
+    let hash_actual: &[u8] = &hash_dir(rom_path).context("calculate hash")?;
+    if hash_actual != hash_expected {
+        let exp = HEXLOWER.encode(hash_expected);
+        let act = HEXLOWER.encode(hash_actual);
+        bail!(
+            "some files were changed, added, or removed, invalid hash:\n  \
+            expected: {exp}\n  \
+            got:      {act}\n\
+            💡 to see which files changed, compare with the original archive using `diff`"
+        );
+    }
+    Ok(())
+}
+
+/// Verify SHA256 hash, public key, and signature.
+pub fn verify_signature(rom_path: &Path) -> Result<()> {
+    verify_hash(rom_path)?;
+    let key_path = rom_path.join(KEY);
+    let key_raw = fs::read(key_path).context("read key from ROM")?;
+    check_signature(rom_path, &key_raw)
+}
+
+/// Check if the ROM is signed and the signature matches the key included in the ROM.
+pub fn signature_status(rom_path: &Path) -> SignatureStatus {
+    if !rom_path.join(SIG).is_file() || !rom_path.join(KEY).is_file() {
+        SignatureStatus::Missing
+    } else if verify_signature(rom_path).is_ok() {
+        SignatureStatus::Valid
+    } else {
+        SignatureStatus::Invalid
+    }
+}
+
+/// Check that the ROM hash is signed by the given public key.
+///
+/// The hash itself must be verified beforehand.
+fn check_signature(rom_path: &Path, key_raw: &[u8]) -> Result<()> {
+    let sig_path = rom_path.join(SIG);
+    if !sig_path.exists() {
+        bail!("the ROM is not signed");
+    }
+    let public_key = RsaPublicKey::from_pkcs1_der(key_raw).context("decode key")?;
+    let verifying_key = VerifyingKey::<Sha256>::new(public_key);
+    let hash: &[u8] = &fs::read(rom_path.join(HASH)).context("read hash file")?;
+    let sig_raw: &[u8] = &fs::read(sig_path).context("read signature")?;
+    let sig = Signature::try_from(sig_raw).context("bad signature")?;
+    if verifying_key.verify_prehash(hash, &sig).is_err() {
+        bail!("invalid signature, the ROM is not signed by this key");
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_verify_hash() {
+        let rom_path = make_tmp_dir();
+        assert!(verify_hash(&rom_path.join("nope")).is_err());
+        fs::write(rom_path.join(META), "meta").unwrap();
+        fs::write(rom_path.join(BIN), "bin").unwrap();
+        let err = verify_hash(&rom_path).unwrap_err();
+        assert_eq!(err.to_string(), "missing files: _hash");
+
+        let hash = hash_dir(&rom_path).unwrap();
+        fs::write(rom_path.join(HASH), hash).unwrap();
+        verify_hash(&rom_path).unwrap();
+
+        fs::write(rom_path.join(BIN), "nib").unwrap();
+        assert!(verify_hash(&rom_path).is_err());
+    }
+
+    #[test]
+    fn test_cmd_verify_key() {
+        use crate::args::{BuildArgs, KeyNewArgs};
+        use crate::build::build;
+        use crate::keys::cmd_key_new;
+        use clap::Parser;
+
+        let vfs = make_tmp_vfs();
+        for author_id in ["greg", "ann"] {
+            let args = KeyNewArgs {
+                author_id: author_id.to_string(),
+                force:     false,
+            };
+            cmd_key_new(&vfs, &args).unwrap();
+        }
+        let root = make_tmp_dir();
+        let config = r#"
+author_id = "greg"
+app_id = "snek"
+author_name = "Greg"
+app_name = "Snek"
+
+[files]
+_bin = { path = "main.wasm", copy = true }
+"#;
+        fs::write(root.join("firefly.toml"), config).unwrap();
+        fs::write(root.join("main.wasm"), b"\0asm\x01\0\0\0").unwrap();
+        let build_args = BuildArgs::parse_from(["build", "--no-tip", root.to_str().unwrap()]);
+        build(vfs.clone(), &build_args).unwrap();
+
+        let pub_path = vfs.join("sys").join("pub").join("greg");
+        let keys = ["greg".to_string(), pub_path.to_str().unwrap().to_string()];
+        for key in keys {
+            let args = VerifyArgs {
+                id:  Some("greg.snek".to_string()),
+                key: Some(key),
+                all: false,
+            };
+            cmd_verify(&vfs, &args).unwrap();
+        }
+        let args = VerifyArgs {
+            id:  Some("greg.snek".to_string()),
+            key: Some("ann".to_string()),
+            all: false,
+        };
+        assert!(cmd_verify(&vfs, &args).is_err());
+    }
+
+    #[test]
+    fn test_check_signature_unsigned() {
+        let rom_path = make_tmp_dir();
+        let err = check_signature(&rom_path, b"").unwrap_err();
+        assert_eq!(err.to_string(), "the ROM is not signed");
+    }
+}
@@ -1,4 +1,4 @@
-use anyhow::Context;
+use anyhow::{bail, Context};
 use directories::ProjectDirs;
 use rand::seq::SliceRandom;
 use rand::{thread_rng, Rng};
@@ -49,6 +49,73 @@ pub fn init_vfs(path: &Path) -> anyhow::Result<()> {
     Ok(())
 }
 
+/// Split the full app ID (like `sys.launcher`) into author ID and app ID.
+pub fn parse_app_id(id: &str) -> anyhow::Result<(String, String)> {
+    let Some((author_id, app_id)) = id.split_once('.') else {
+        bail!("the app ID must be in the format author_id.app_id");
+    };
+    if let Err(err) = firefly_meta::validate_id(author_id) {
+        bail!("invalid author ID: {err}");
+    }
+    if let Err(err) = firefly_meta::validate_id(app_id) {
+        bail!("invalid app ID: {err}");
+    }
+    Ok((author_id.to_string(), app_id.to_string()))
+}
+
+/// List author and app IDs of all apps installed in the VFS, sorted.
+pub fn list_apps(vfs: &Path) -> anyhow::Result<Vec<(String, String)>> {
+    let mut apps = Vec::new();
+    let roms_path = vfs.join("roms");
+    if !roms_path.exists() {
+        return Ok(apps);
+    }
+    let authors = fs::read_dir(roms_path).context("read roms directory")?;
+    for author in authors {
+        let author = author.context("read author directory")?;
+        if !author.path().is_dir() {
+            continue;
+        }
+        let Ok(author_id) = author.file_name().into_string() else {
+            continue;
+        };
+        let roms = fs::read_dir(author.path()).context("read author directory")?;
+        for rom in roms {
+            let rom = rom.context("read ROM directory")?;
+            if !rom.path().is_dir() {
+                continue;
+            }
+            let Ok(app_id) = rom.file_name().into_string() else {
+                continue;
+            };
+            apps.push((author_id.clone(), app_id));
+        }
+    }
+    apps.sort();
+    Ok(apps)
+}
+
+/// Get the total size in bytes of all files in the directory, recursively.
+///
+/// Returns zero if the directory doesn't exist.
+pub fn dir_size(path: &Path) -> u64 {
+    let Ok(entries) = fs::read_dir(path) else {
+        return 0;
+    };
+    let mut size = 0;
+    for entry in entries.flatten() {
+        let Ok(meta) = entry.metadata() else {
+            continue;
+        };
+        if meta.is_dir() {
+            size += dir_size(&entry.path());
+        } else {
+            size += meta.len();
+        }
+    }
+    size
+}
+
 /// Generate a random device name.
 fn generate_name() -> String {
     let adj = get_random_line(include_str!("names_adj.txt"));
@@ -140,6 +207,42 @@ mod tests {
         assert!(name.len() <= 15);
     }
 
+    #[test]
+    fn test_parse_app_id() {
+        let (author_id, app_id) = parse_app_id("sys.launcher").unwrap();
+        assert_eq!(author_id, "sys");
+        assert_eq!(app_id, "launcher");
+        assert!(parse_app_id("launcher").is_err());
+        assert!(parse_app_id("sys.").is_err());
+        assert!(parse_app_id("Sys.launcher").is_err());
+    }
+
+    #[test]
+    fn test_list_apps() {
+        let vfs = std::env::temp_dir().join("test_list_apps");
+        _ = std::fs::remove_dir_all(&vfs);
+        assert_eq!(list_apps(&vfs).unwrap().len(), 0);
+        init_vfs(&vfs).unwrap();
+        assert_eq!(list_apps(&vfs).unwrap().len(), 0);
+        std::fs::create_dir_all(vfs.join("roms").join("sys").join("launcher")).unwrap();
+        std::fs::create_dir_all(vfs.join("roms").join("greg").join("snek")).unwrap();
+        std::fs::create_dir_all(vfs.join("roms").join("greg").join("chess")).unwrap();
+        let apps = list_apps(&vfs).unwrap();
+        let apps: Vec<_> = apps.iter().map(|(a, b)| format!("{a}.{b}")).collect();
+        assert_eq!(apps, vec!["greg.chess", "greg.snek", "sys.launcher"]);
+    }
+
+    #[test]
+    fn test_dir_size() {
+        let dir = std::env::temp_dir().join("test_dir_size");
+        _ = std::fs::remove_dir_all(&dir);
+        assert_eq!(dir_size(&dir), 0);
+        std::fs::create_dir_all(dir.join("sub")).unwrap();
+        std::fs::write(dir.join("a"), "hello").unwrap();
+        std::fs::write(dir.join("sub").join("b"), "hi").unwrap();
+        assert_eq!(dir_size(&dir), 7);
+    }
+
     #[test]
     fn test_generate_name() {
         for _ in 0..1000 {
@@ -3,8 +3,134 @@ use std::io::Write;
 use std::path::Path;
 use std::process::Command;
 use wasm_encoder::{Component, ComponentSectionId, Encode, Module, Section};
-use wasmparser::Payload::{ComponentSection, CustomSection, End, ModuleSection, Version};
-use wasmparser::{Encoding, Parser};
+use wasmparser::Payload::{
+    ComponentSection, CustomSection, End, ImportSection, ModuleSection, Version,
+};
+use wasmparser::{Encoding, Parser, TypeRef};
+
+/// The version of [firefly-runtime] that [`HOST_FUNCS`] mirrors.
+///
+/// [firefly-runtime]: https://github.com/firefly-zero/firefly-runtime
+const RUNTIME_VERSION: &str = "0.3";
+
+/// Host functions provided by the firefly runtime, grouped by module.
+///
+/// Copied from the functions that firefly-runtime [`RUNTIME_VERSION`]
+/// registers in the wasm linker. Update both the list and
+/// the version when the runtime gets new functions. Until then, the check
+/// can be disabled with `--no-check-imports` or `check_imports = false`.
+const HOST_FUNCS: &[(&str, &[&str])] = &[
+    (
+        "graphics",
+        &[
+            "clear_screen",
+            "set_color",
+            "draw_point",
+            "draw_line",
+            "draw_rect",
+            "draw_rounded_rect",
+            "draw_circle",
+            "draw_ellipse",
+            "draw_triangle",
+            "draw_arc",
+            "draw_sector",
+            "draw_text",
+            "draw_qr",
+            "draw_sub_image",
+            "draw_image",
+            "set_canvas",
+            "unset_canvas",
+        ],
+    ),
+    ("input", &["read_pad", "read_buttons"]),
+    ("menu", &["add_menu_item", "remove_menu_item", "open_menu"]),
+    (
+        "fs",
+        &["get_file_size", "load_file", "dump_file", "remove_file"],
+    ),
+    ("net", &["get_me", "get_peers", "save_stash", "load_stash"]),
+    ("stats", &["add_progress", "add_score"]),
+    (
+        "misc",
+        &[
+            "log_debug",
+            "log_error",
+            "set_seed",
+            "get_random",
+            "get_name",
+            "get_settings",
+            "restart",
+            "quit",
+        ],
+    ),
+    (
+        "audio",
+        &[
+            "reset",
+            "reset_all",
+            "clear",
+            "add_empty",
+            "add_file",
+            "add_mix",
+            "add_all_for_one",
+            "add_gain",
+            "add_loop",
+            "add_concat",
+            "add_pan",
+            "add_mute",
+            "add_pause",
+            "add_track_position",
+            "add_low_pass",
+            "add_high_pass",
+            "add_take_left",
+            "add_take_right",
+            "add_swap",
+            "add_clip",
+            "add_sine",
+            "add_square",
+            "add_sawtooth",
+            "add_triangle",
+            "add_noise",
+            "add_zero",
+            "mod_linear",
+            "mod_hold",
+            "mod_sine",
+        ],
+    ),
+    (
+        "sudo",
+        &[
+            "list_dirs",
+            "list_dirs_buf_size",
+            "list_files",
+            "list_files_buf_size",
+            "get_file_size",
+            "load_file",
+            "run_app",
+        ],
+    ),
+    // The subset of WASI preview 1 that the runtime stubs out.
+    // It is required by TinyGo and some other toolchains.
+    (
+        "wasi_snapshot_preview1",
+        &[
+            "args_get",
+            "args_sizes_get",
+            "environ_get",
+            "environ_sizes_get",
+            "clock_time_get",
+            "fd_close",
+            "fd_fdstat_get",
+            "fd_prestat_get",
+            "fd_prestat_dir_name",
+            "fd_seek",
+            "fd_write",
+            "proc_exit",
+            "random_get",
+            "sched_yield",
+        ],
+    ),
+];
 
 /// Remove custom sections from the given wasm file.
 ///
@@ -61,6 +187,46 @@ pub fn strip_custom(bin_path: &Path) -> anyhow::Result<()> {
     Ok(())
 }
 
+/// Make sure the wasm binary imports only functions that the runtime provides.
+///
+/// Otherwise, the app will fail only when launched on the device.
+pub fn check_imports(bin_path: &Path) -> anyhow::Result<()> {
+    let input_bytes = std::fs::read(bin_path).context("read wasm binary")?;
+    let parser = Parser::new(0);
+    let mut unknown = Vec::new();
+    for payload in parser.parse_all(&input_bytes) {
+        let payload = payload.context("parse wasm binary")?;
+        let ImportSection(imports) = payload else {
+            continue;
+        };
+        for import in imports {
+            let import = import.context("parse import")?;
+            if !matches!(import.ty, TypeRef::Func(_)) {
+                continue;
+            }
+            if !is_host_func(import.module, import.name) {
+                unknown.push(format!("{}.{}", import.module, import.name));
+            }
+        }
+    }
+    if !unknown.is_empty() {
+        let unknown = unknown.join("\n  ");
+        bail!(
+            "the binary imports functions not provided by firefly-runtime {RUNTIME_VERSION}:\n  {unknown}\n\
+            💡 if the runtime is newer than firefly_cli, skip the check with \
+            --no-check-imports or `check_imports = false` in the [build] section of firefly.toml"
+        );
+    }
+    Ok(())
+}
+
+/// Check if the function is provided by the firefly runtime.
+fn is_host_func(module: &str, name: &str) -> bool {
+    HOST_FUNCS
+        .iter()
+        .any(|(m, funcs)| *m == module && funcs.contains(&name))
+}
+
 /// Run [wasm-opt] on the given wasm binary.
 ///
 /// [wasm-opt]: https://github.com/WebAssembly/binaryen
@@ -71,7 +237,7 @@ pub fn optimize(bin_path: &Path) -> anyhow::Result<()> {
 
     let output = Command::new("wasm-opt").arg("--version").output();
     if output.is_err() {
-        println!("WARNING: wasm-opt not installed, the binary won't be optimized.");
+        eprintln!("WARNING: wasm-opt not installed, the binary won't be optimized.");
         return Ok(());
     }
 
@@ -81,10 +247,25 @@ pub fn optimize(bin_path: &Path) -> anyhow::Result<()> {
         .output()
         .context("run wasm-opt")?;
     if !output.status.success() {
-        std::io::stdout().write_all(&output.stdout)?;
+        std::io::stderr().write_all(&output.stdout)?;
         std::io::stderr().write_all(&output.stderr)?;
         let code = output.status.code().unwrap_or(1);
         bail!("subprocess exited with status code {code}");
     }
     Ok(())
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_host_func() {
+        assert!(is_host_func("graphics", "draw_line"));
+        assert!(is_host_func("misc", "log_debug"));
+        assert!(is_host_func("wasi_snapshot_preview1", "fd_write"));
+        assert!(!is_host_func("graphics", "log_debug"));
+        assert!(!is_host_func("env", "draw_line"));
+        assert!(!is_host_func("wasi_snapshot_preview1", "path_open"));
+    }
+}
@@ -0,0 +1,215 @@
+use crate::args::BuildArgs;
+use crate::build::build;
+use crate::config::Config;
+use crate::settings::Settings;
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::thread::sleep;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// How often to check the project files for changes.
+const POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+/// How long the files must stay unchanged before a rebuild is triggered.
+///
+/// Editors often write a file in several steps, and some save all open files at once.
+/// Waiting for the changes to settle makes sure that a burst of writes
+/// triggers only one rebuild.
+const DEBOUNCE: Duration = Duration::from_millis(200);
+
+/// Directories that contain build artifacts or dependencies, not the project source.
+const IGNORED_DIRS: &[&str] = &[
+    "target",
+    "zig-out",
+    "zig-cache",
+    "node_modules",
+    "__pycache__",
+];
+
+/// Files in the project root that are produced by the build itself.
+const IGNORED_FILES: &[&str] = &[
+    // Zig build-exe output, moved into the ROM after the build.
+    "main.wasm",
+];
+
+/// Modification time of every watched file.
+type Snapshot = HashMap<PathBuf, SystemTime>;
+
+/// Files to watch in addition to the project root and files to skip.
+#[derive(Default)]
+struct Watched {
+    /// Files from the `files` and `atlases` sections of firefly.toml.
+    ///
+    /// These files might be located outside of the project root
+    /// and so need to be watched explicitly.
+    extra: Vec<PathBuf>,
+
+    /// Files generated by the build, like the output of `codegen`.
+    generated: Vec<PathBuf>,
+}
+
+/// Build the project and then rebuild it every time any project file changes.
+///
+/// Build errors are reported but don't stop the watcher.
+pub fn watch_build(vfs: &Path, args: &BuildArgs) -> anyhow::Result<()> {
+    println!("👀 watching for changes...");
+    loop {
+        // The config might have changed, so the list of asset files might have changed too.
+        let watched = find_watched(vfs, args);
+        // Take the snapshot before the build so that the files changed
+        // while the build is running trigger another rebuild.
+        let snapshot = take_snapshot(&args.root, &watched);
+        rebuild(vfs, args);
+        wait_for_changes(&args.root, &watched, &snapshot);
+    }
+}
+
+/// Block until the project files differ from the snapshot and then stay unchanged for a while.
+fn wait_for_changes(root: &Path, watched: &Watched, snapshot: &Snapshot) {
+    let mut pending = loop {
+        sleep(POLL_INTERVAL);
+        let next = take_snapshot(root, watched);
+        if next != *snapshot {
+            break next;
+        }
+    };
+    loop {
+        sleep(DEBOUNCE);
+        let next = take_snapshot(root, watched);
+        if next == pending {
+            return;
+        }
+        pending = next;
+    }
+}
+
+/// Run the build and report the result with a timestamp.
+fn rebuild(vfs: &Path, args: &BuildArgs) {
+    let result = build(vfs.to_path_buf(), args);
+    let now = format_time(SystemTime::now());
+    match result {
+        Ok(()) => println!("[{now}] ✅ build succeeded"),
+        Err(err) => eprintln!("[{now}] 💥 build failed: {err:#}"),
+    }
+}
+
+/// Find the files outside of the project root to watch and the build outputs to skip.
+fn find_watched(vfs: &Path, args: &BuildArgs) -> Watched {
+    // Unknown keys are reported by the build itself.
+    let author = args.author.as_deref();
+    let Ok(config) = Config::load_with(vfs.to_path_buf(), &args.root, true, author, Settings::load)
+    else {
+        return Watched::default();
+    };
+    let mut watched = Watched::default();
+    if let Some(files) = &config.files {
+        for file_config in files.values() {
+            watched.extra.push(config.root_path.join(&file_config.path));
+        }
+    }
+    if let Some(atlases) = &config.atlases {
+        for atlas_config in atlases.values() {
+            for path in &atlas_config.files {
+                watched.extra.push(config.root_path.join(path));
+            }
+        }
+    }
+    if let Some(codegen) = &config.codegen {
+        watched.generated.push(config.root_path.join(&codegen.path));
+    }
+    watched
+}
+
+/// Collect modification times for all project files.
+fn take_snapshot(root: &Path, watched: &Watched) -> Snapshot {
+    let mut snapshot = Snapshot::new();
+    collect_mtimes(root, &mut snapshot);
+    for path in &watched.extra {
+        if let Ok(mtime) = fs::metadata(path).and_then(|meta| meta.modified()) {
+            snapshot.insert(path.clone(), mtime);
+        }
+    }
+    for path in &watched.generated {
+        snapshot.remove(path);
+    }
+    snapshot
+}
+
+/// Recursively walk the directory and record modification time of every file in it.
+///
+/// Hidden files and directories (including the local VFS), directories
+/// with build artifacts, and files produced by the build are skipped.
+fn collect_mtimes(dir: &Path, snapshot: &mut Snapshot) {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return;
+    };
+    for entry in entries {
+        let Ok(entry) = entry else { continue };
+        let name = entry.file_name();
+        let Some(name) = name.to_str() else { continue };
+        if name.starts_with('.') {
+            continue;
+        }
+        let Ok(file_type) = entry.file_type() else {
+            continue;
+        };
+        let path = entry.path();
+        if file_type.is_dir() {
+            if !IGNORED_DIRS.contains(&name) {
+                collect_mtimes(&path, snapshot);
+            }
+            continue;
+        }
+        if IGNORED_FILES.contains(&name) {
+            continue;
+        }
+        if let Ok(mtime) = entry.metadata().and_then(|meta| meta.modified()) {
+            snapshot.insert(path, mtime);
+        }
+    }
+}
+
+/// Format the time of the day (in UTC) as HH:MM:SS.
+fn format_time(time: SystemTime) -> String {
+    let secs = time.duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs());
+    let hours = secs / 3600 % 24;
+    let minutes = secs / 60 % 60;
+    let seconds = secs % 60;
+    format!("{hours:02}:{minutes:02}:{seconds:02}")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_helpers::*;
+
+    #[test]
+    fn test_format_time() {
+        let time = UNIX_EPOCH + Duration::from_secs(86400 * 3 + 3600 * 13 + 60 * 5 + 9);
+        assert_eq!(format_time(time), "13:05:09");
+    }
+
+    #[test]
+    fn test_take_snapshot() {
+        let root = make_tmp_dir();
+        fs::write(root.join("main.go"), "package main").unwrap();
+        fs::create_dir_all(root.join("target")).unwrap();
+        fs::write(root.join("target").join("app.wasm"), "").unwrap();
+        fs::create_dir_all(root.join(".firefly")).unwrap();
+        fs::write(root.join(".firefly").join("name"), "").unwrap();
+        fs::create_dir_all(root.join("assets")).unwrap();
+        fs::write(root.join("assets").join("hero.png"), "").unwrap();
+        fs::write(root.join("main.wasm"), "").unwrap();
+        fs::write(root.join("assets.go"), "").unwrap();
+
+        let watched = Watched {
+            extra:     Vec::new(),
+            generated: vec![root.join("assets.go")],
+        };
+        let snapshot = take_snapshot(&root, &watched);
+        assert_eq!(snapshot.len(), 2);
+        assert!(snapshot.contains_key(&root.join("main.go")));
+        assert!(snapshot.contains_key(&root.join("assets").join("hero.png")));
+    }
+}